@@ -0,0 +1,333 @@
+// Package account manages accounts owning clusters, their API keys and
+// quotas.
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+	"github.com/storageos/discovery/util/uuid"
+)
+
+// errors
+var (
+	ErrAccountExists = errors.New("account already exists")
+	ErrInvalidQuota  = errors.New("invalid quota")
+	ErrInvalidKey    = errors.New("invalid API key")
+	ErrInvalidID     = errors.New("invalid account ID")
+)
+
+// Bucket - name of the bucket accounts are stored in next to clusters
+const Bucket = "accounts"
+
+const (
+	accountPrefix = "account/"
+	keyPrefix     = "key/"
+)
+
+// Record - empty record of the type stored under key, e.g. for migrating or
+// validating the bucket. Unknown keys return nil.
+func Record(storeKey string) interface{} {
+	switch {
+	case strings.HasPrefix(storeKey, accountPrefix):
+		return &types.Account{}
+	case strings.HasPrefix(storeKey, keyPrefix):
+		return &key{}
+	}
+	return nil
+}
+
+// key - stored API key, only the hash of the secret is kept
+type key struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"accountID"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Manager - account manager
+type Manager struct {
+	mu         *sync.Mutex
+	store      store.Store
+	serializer codecs.Serializer
+}
+
+// New - create new account manager
+func New(store store.Store, serializer codecs.Serializer) *Manager {
+	return &Manager{
+		mu:         &sync.Mutex{},
+		store:      store,
+		serializer: serializer,
+	}
+}
+
+func quotaValid(q types.Quota) error {
+	if q.MaxClusters < 0 || q.MaxSize < 0 || q.MaxTTL < 0 {
+		return fmt.Errorf("%w: limits can't be negative", ErrInvalidQuota)
+	}
+	return nil
+}
+
+// Create - create new account, ID is generated when empty
+func (m *Manager) Create(ctx context.Context, account *types.Account) (*types.Account, error) {
+	if account.ID == "" {
+		account.ID = uuid.Generate()
+	}
+	if strings.ContainsAny(account.ID, "/.") {
+		return nil, fmt.Errorf("%w: %q, '/' and '.' are not allowed", ErrInvalidID, account.ID)
+	}
+	if err := quotaValid(account.Quota); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	account.CreatedAt = now
+	account.UpdatedAt = now
+
+	bts, err := m.serializer.Encode(account)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.store.Create(ctx, accountPrefix+account.ID, bts, 0)
+	if err == store.ErrExist {
+		return nil, ErrAccountExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Get - get account by ID
+func (m *Manager) Get(ctx context.Context, id string) (*types.Account, error) {
+	kvp, err := m.store.Get(ctx, accountPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var account types.Account
+	if err := m.serializer.Decode(kvp.Value, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// List - list all accounts
+func (m *Manager) List(ctx context.Context) ([]*types.Account, error) {
+	kvps, err := m.store.Enumerate(ctx, accountPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*types.Account, 0, len(kvps))
+	for _, kvp := range kvps {
+		var account types.Account
+		if err := m.serializer.Decode(kvp.Value, &account); err != nil {
+			return nil, fmt.Errorf("failed to decode account %s: %s", kvp.Key, err)
+		}
+		accounts = append(accounts, &account)
+	}
+	return accounts, nil
+}
+
+// Update - changes name or quota of the account, lowered quotas only apply
+// to new clusters
+func (m *Manager) Update(ctx context.Context, id string, update *types.AccountUpdate) (*types.Account, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Name != nil {
+		account.Name = *update.Name
+	}
+	if update.Quota != nil {
+		if err := quotaValid(*update.Quota); err != nil {
+			return nil, err
+		}
+		account.Quota = *update.Quota
+	}
+	account.UpdatedAt = time.Now()
+
+	bts, err := m.serializer.Encode(account)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.store.Put(ctx, accountPrefix+account.ID, bts, 0); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Delete - deletes account together with its API keys, clusters of the
+// account are left untouched
+func (m *Manager) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	}
+
+	keys, err := m.keys(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// don't leave the account behind without some of its keys
+	ctx = context.WithoutCancel(ctx)
+	for _, k := range keys {
+		if err := m.store.Delete(ctx, keyPrefix+k.ID); err != nil {
+			return err
+		}
+	}
+	return m.store.Delete(ctx, accountPrefix+id)
+}
+
+// Quota - quota of the account, implements cluster.QuotaSource
+func (m *Manager) Quota(ctx context.Context, accountID string) (*types.Quota, error) {
+	account, err := m.Get(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return &account.Quota, nil
+}
+
+// CreateKey - creates API key for the account, the returned key is the
+// only time the secret is available
+func (m *Manager) CreateKey(ctx context.Context, accountID string) (*types.APIKey, error) {
+	if _, err := m.Get(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encoded := hex.EncodeToString(secret)
+
+	k := key{
+		ID:        uuid.Generate(),
+		AccountID: accountID,
+		Hash:      hash(encoded),
+		CreatedAt: time.Now(),
+	}
+
+	bts, err := m.serializer.Encode(&k)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.store.Create(ctx, keyPrefix+k.ID, bts, 0); err != nil {
+		return nil, err
+	}
+
+	return &types.APIKey{
+		ID:        k.ID,
+		AccountID: accountID,
+		Key:       k.ID + "." + encoded,
+		CreatedAt: k.CreatedAt,
+	}, nil
+}
+
+// Keys - API keys of the account, without secrets
+func (m *Manager) Keys(ctx context.Context, accountID string) ([]*types.APIKey, error) {
+	if _, err := m.Get(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	keys, err := m.keys(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys := make([]*types.APIKey, 0, len(keys))
+	for _, k := range keys {
+		apiKeys = append(apiKeys, &types.APIKey{ID: k.ID, AccountID: k.AccountID, CreatedAt: k.CreatedAt})
+	}
+	return apiKeys, nil
+}
+
+func (m *Manager) keys(ctx context.Context, accountID string) ([]*key, error) {
+	kvps, err := m.store.Enumerate(ctx, keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*key
+	for _, kvp := range kvps {
+		var k key
+		if err := m.serializer.Decode(kvp.Value, &k); err != nil {
+			return nil, fmt.Errorf("failed to decode API key %s: %s", kvp.Key, err)
+		}
+		if k.AccountID == accountID {
+			keys = append(keys, &k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *Manager) getKey(ctx context.Context, id string) (*key, error) {
+	kvp, err := m.store.Get(ctx, keyPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+
+	var k key
+	if err := m.serializer.Decode(kvp.Value, &k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// DeleteKey - revokes API key of the account
+func (m *Manager) DeleteKey(ctx context.Context, accountID, keyID string) error {
+	k, err := m.getKey(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if k.AccountID != accountID {
+		return store.ErrNotFound
+	}
+	return m.store.Delete(ctx, keyPrefix+keyID)
+}
+
+// Authenticate - account and key ID of an "<id>.<secret>" API key
+func (m *Manager) Authenticate(ctx context.Context, apiKey string) (accountID, keyID string, err error) {
+	parts := strings.SplitN(apiKey, ".", 2)
+	if len(parts) != 2 {
+		return "", "", ErrInvalidKey
+	}
+
+	k, err := m.getKey(ctx, parts[0])
+	if err == store.ErrNotFound {
+		return "", "", ErrInvalidKey
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash(parts[1])), []byte(k.Hash)) != 1 {
+		return "", "", ErrInvalidKey
+	}
+	return k.AccountID, k.ID, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
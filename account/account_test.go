@@ -0,0 +1,134 @@
+package account
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+func setupManager(t *testing.T) (*Manager, func()) {
+	file, err := ioutil.TempFile("", "testaccounts")
+	if err != nil {
+		t.Fatalf("failed to get temp file: %s", err)
+	}
+
+	db, err := boltdb.New(file.Name())
+	if err != nil {
+		t.Fatalf("failed to create db: %s", err)
+	}
+
+	return New(db, codecs.DefaultSerializer()), func() {
+		db.Close()
+		os.Remove(file.Name())
+	}
+}
+
+func TestAccount(t *testing.T) {
+	ctx := context.Background()
+
+	m, teardown := setupManager(t)
+	defer teardown()
+
+	if _, err := m.Create(ctx, &types.Account{Quota: types.Quota{MaxSize: -1}}); err == nil {
+		t.Errorf("expected negative quota to be rejected")
+	}
+	if _, err := m.Create(ctx, &types.Account{ID: "a/b"}); err == nil {
+		t.Errorf("expected ID with a slash to be rejected")
+	}
+
+	created, err := m.Create(ctx, &types.Account{ID: "acme", Name: "ACME", Quota: types.Quota{MaxClusters: 2}})
+	if err != nil {
+		t.Fatalf("failed to create account: %s", err)
+	}
+	if created.CreatedAt.IsZero() {
+		t.Errorf("expected creation time to be set")
+	}
+	if _, err := m.Create(ctx, &types.Account{ID: "acme"}); err != ErrAccountExists {
+		t.Errorf("expected ErrAccountExists, got: %v", err)
+	}
+
+	quota := types.Quota{MaxClusters: 5, MaxTTL: 3600}
+	if _, err := m.Update(ctx, "acme", &types.AccountUpdate{Quota: &quota}); err != nil {
+		t.Fatalf("failed to update account: %s", err)
+	}
+	q, err := m.Quota(ctx, "acme")
+	if err != nil {
+		t.Fatalf("failed to get quota: %s", err)
+	}
+	if *q != quota {
+		t.Errorf("unexpected quota: %+v", q)
+	}
+
+	accounts, err := m.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list accounts: %s", err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "ACME" {
+		t.Errorf("unexpected accounts: %+v", accounts)
+	}
+
+	if err := m.Delete(ctx, "acme"); err != nil {
+		t.Fatalf("failed to delete account: %s", err)
+	}
+	if _, err := m.Get(ctx, "acme"); err != store.ErrNotFound {
+		t.Errorf("expected deleted account to be gone, got: %v", err)
+	}
+}
+
+func TestAccountKeys(t *testing.T) {
+	ctx := context.Background()
+
+	m, teardown := setupManager(t)
+	defer teardown()
+
+	if _, err := m.CreateKey(ctx, "missing"); err != store.ErrNotFound {
+		t.Errorf("expected ErrNotFound for unknown account, got: %v", err)
+	}
+
+	if _, err := m.Create(ctx, &types.Account{ID: "acme"}); err != nil {
+		t.Fatalf("failed to create account: %s", err)
+	}
+
+	key, err := m.CreateKey(ctx, "acme")
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	accountID, keyID, err := m.Authenticate(ctx, key.Key)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	if accountID != "acme" || keyID != key.ID {
+		t.Errorf("unexpected credentials: account %s, key %s", accountID, keyID)
+	}
+
+	for _, invalid := range []string{"", key.ID, key.ID + ".wrong", "missing.secret"} {
+		if _, _, err := m.Authenticate(ctx, invalid); err != ErrInvalidKey {
+			t.Errorf("expected ErrInvalidKey for %q, got: %v", invalid, err)
+		}
+	}
+
+	keys, err := m.Keys(ctx, "acme")
+	if err != nil {
+		t.Fatalf("failed to list keys: %s", err)
+	}
+	if len(keys) != 1 || keys[0].Key != "" {
+		t.Errorf("expected a single key without secret, got: %+v", keys)
+	}
+
+	if err := m.DeleteKey(ctx, "other", key.ID); err != store.ErrNotFound {
+		t.Errorf("expected key of another account not to be found, got: %v", err)
+	}
+	if err := m.DeleteKey(ctx, "acme", key.ID); err != nil {
+		t.Fatalf("failed to delete key: %s", err)
+	}
+	if _, _, err := m.Authenticate(ctx, key.Key); err != ErrInvalidKey {
+		t.Errorf("expected revoked key to be rejected, got: %v", err)
+	}
+}
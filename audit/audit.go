@@ -0,0 +1,377 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+	"github.com/storageos/discovery/util/logging"
+	"github.com/storageos/discovery/util/uuid"
+)
+
+// Action - mutation recorded in the audit log
+type Action string
+
+// audited actions
+const (
+	ActionCreate     Action = "create"
+	ActionRegister   Action = "register"
+	ActionUpdateNode Action = "update-node"
+	ActionUpdate     Action = "update"
+	ActionDelete     Action = "delete"
+	ActionRestore    Action = "restore"
+	ActionImport     Action = "import"
+	ActionDeregister Action = "deregister"
+	ActionConfirm    Action = "confirm-leader"
+	ActionHandover   Action = "handover-leader"
+	// ActionPhase is recorded by the server when cluster changes phase
+	ActionPhase Action = "phase"
+	// ActionRestoreSnapshot is recorded for clusters changed by restoring
+	// a database snapshot
+	ActionRestoreSnapshot Action = "restore-snapshot"
+)
+
+// Actor - who performed the action
+type Actor struct {
+	IP string `json:"ip,omitempty"`
+	// KeyID identifies the credential used, never the secret itself
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// Change - single field changed by the action
+type Change struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// Entry - audit log entry
+type Entry struct {
+	ID        string         `json:"id"`
+	ClusterID string         `json:"clusterID"`
+	Action    Action         `json:"action"`
+	Actor     Actor          `json:"actor"`
+	RequestID string         `json:"requestID,omitempty"`
+	Changes   []Change       `json:"changes,omitempty"`
+	Before    *types.Cluster `json:"before,omitempty"`
+	After     *types.Cluster `json:"after,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// Query - audit log query, zero values match everything
+type Query struct {
+	ClusterID string
+	Action    Action
+	ActorIP   string
+	Since     time.Time
+	Until     time.Time
+	// Limit returns only the most recent entries
+	Limit int
+}
+
+func (q *Query) match(e *Entry) bool {
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.ActorIP != "" && e.Actor.IP != q.ActorIP {
+		return false
+	}
+	if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Timestamp.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Log - append-only audit log, entries are only ever removed once they
+// are older than the retention period
+type Log struct {
+	store      store.Store
+	serializer codecs.Serializer
+	retention  time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// New - create new audit log, retention of 0 keeps entries forever
+func New(store store.Store, serializer codecs.Serializer, retention time.Duration) *Log {
+	return &Log{
+		store:      store,
+		serializer: serializer,
+		retention:  retention,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Bucket - name of the bucket the log is stored in next to clusters
+const Bucket = "audit"
+
+// Record - empty record of the type stored under key, e.g. for migrating or
+// validating the bucket
+func Record(key string) interface{} {
+	return &Entry{}
+}
+
+// entries are keyed by cluster ID and a sortable timestamp so history of a
+// single cluster is a prefix scan
+func entryKey(e *Entry) string {
+	return fmt.Sprintf("%s/%020d/%s", e.ClusterID, e.Timestamp.UnixNano(), e.ID)
+}
+
+// Record - appends entry to the log, ID, timestamp and changes are filled
+// in when missing
+func (l *Log) Record(ctx context.Context, e *Entry) error {
+	if e.ClusterID == "" {
+		return fmt.Errorf("audit entry cluster ID missing")
+	}
+	if e.ID == "" {
+		e.ID = uuid.Generate()
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+	if e.Changes == nil {
+		e.Changes = Diff(e.Before, e.After)
+	}
+
+	bts, err := l.serializer.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.store.Create(ctx, entryKey(e), bts, 0)
+	return err
+}
+
+// History - all entries of the cluster, oldest first
+func (l *Log) History(ctx context.Context, clusterID string) ([]*Entry, error) {
+	return l.Query(ctx, Query{ClusterID: clusterID})
+}
+
+// Query - entries matching the query, oldest first
+func (l *Log) Query(ctx context.Context, q Query) ([]*Entry, error) {
+	prefix := ""
+	if q.ClusterID != "" {
+		prefix = q.ClusterID + "/"
+	}
+
+	kvps, err := l.store.Enumerate(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*Entry{}
+	for _, kvp := range kvps {
+		var e Entry
+		if err := l.serializer.Decode(kvp.Value, &e); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry %s: %s", kvp.Key, err)
+		}
+		if q.match(&e) {
+			entries = append(entries, &e)
+		}
+	}
+
+	// keys are only ordered within a single cluster
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+	return entries, nil
+}
+
+// Prune - removes entries older than the retention period
+func (l *Log) Prune(now time.Time) (int, error) {
+	if l.retention <= 0 {
+		return 0, nil
+	}
+	ctx := context.Background()
+
+	kvps, err := l.store.Enumerate(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := now.Add(-l.retention).UnixNano()
+	pruned := 0
+	for _, kvp := range kvps {
+		parts := strings.SplitN(kvp.Key, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var ts int64
+		if _, err := fmt.Sscanf(parts[1], "%d", &ts); err != nil || ts >= cutoff {
+			continue
+		}
+		if err := l.store.Delete(ctx, kvp.Key); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Start - prunes expired entries in the background
+func (l *Log) Start(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				n, err := l.Prune(time.Now())
+				if err != nil {
+					slog.Error("failed to prune audit log", logging.FieldError, err)
+					continue
+				}
+				if n > 0 {
+					slog.Info("pruned audit log entries", "count", n)
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop - stops background pruning
+func (l *Log) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+// Diff - fields changed between two versions of a cluster, either of them
+// can be nil
+func Diff(before, after *types.Cluster) []Change {
+	if before == nil {
+		before = &types.Cluster{}
+	}
+	if after == nil {
+		after = &types.Cluster{}
+	}
+
+	var changes []Change
+	add := func(field, from, to string) {
+		if from != to {
+			changes = append(changes, Change{Field: field, From: from, To: to})
+		}
+	}
+
+	add("id", before.ID, after.ID)
+	add("name", before.Name, after.Name)
+	add("accountID", before.AccountID, after.AccountID)
+	if !timeEqual(before.ExpiresAt, after.ExpiresAt) {
+		changes = append(changes, Change{Field: "expiresAt", From: timeString(before.ExpiresAt), To: timeString(after.ExpiresAt)})
+	}
+	add("phase", string(before.Phase), string(after.Phase))
+	add("leader", leaderString(before.Leader), leaderString(after.Leader))
+	if before.Size != after.Size {
+		changes = append(changes, Change{Field: "size", From: sizeString(before.Size), To: sizeString(after.Size)})
+	}
+
+	beforeNodes := make(map[string]*types.Node, len(before.Nodes))
+	for _, n := range before.Nodes {
+		beforeNodes[nodeKey(n)] = n
+	}
+	for _, n := range after.Nodes {
+		key := nodeKey(n)
+		old, ok := beforeNodes[key]
+		if !ok {
+			add("nodes", "", nodeString(n))
+			continue
+		}
+		delete(beforeNodes, key)
+
+		prefix := "nodes[" + key + "]."
+		add(prefix+"name", old.Name, n.Name)
+		add(prefix+"advertiseAddress", old.AdvertiseAddress, n.AdvertiseAddress)
+		add(prefix+"endpoints", endpointsString(old.Endpoints), endpointsString(n.Endpoints))
+		add(prefix+"role", old.Role, n.Role)
+		add(prefix+"zone", old.Zone, n.Zone)
+		add(prefix+"region", old.Region, n.Region)
+		add(prefix+"version", old.Version, n.Version)
+		add(prefix+"labels", labelsString(old.Labels), labelsString(n.Labels))
+	}
+	for _, n := range before.Nodes {
+		if _, ok := beforeNodes[nodeKey(n)]; ok {
+			add("nodes", nodeString(n), "")
+		}
+	}
+
+	return changes
+}
+
+func sizeString(size int) string {
+	if size == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+// nodeKey - nodes are matched by ID, falling back to name for nodes
+// registered without one
+func nodeKey(n *types.Node) string {
+	if n.ID != "" {
+		return n.ID
+	}
+	return n.Name
+}
+
+func timeEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func timeString(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func leaderString(l *types.Leader) string {
+	if l == nil {
+		return ""
+	}
+	if l.ConfirmedAt != nil {
+		return fmt.Sprintf("%s (epoch %d, confirmed)", l.NodeID, l.Epoch)
+	}
+	return fmt.Sprintf("%s (epoch %d)", l.NodeID, l.Epoch)
+}
+
+func endpointsString(endpoints []types.Endpoint) string {
+	pairs := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		pairs = append(pairs, e.Name+"="+e.URL)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func labelsString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func nodeString(n *types.Node) string {
+	return fmt.Sprintf("%s (%s) %s", n.Name, n.ID, n.AdvertiseAddress)
+}
@@ -0,0 +1,79 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+func TestLogHistoryAndPrune(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "testaudit")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := boltdb.New(dir + "/testdb")
+	if err != nil {
+		t.Fatalf("failed to create db: %s", err)
+	}
+	defer db.Close()
+
+	l := New(db, codecs.DefaultSerializer(), time.Hour)
+
+	created := &types.Cluster{ID: "cluster-1", Size: 3}
+	registered := &types.Cluster{ID: "cluster-1", Size: 3, Nodes: []*types.Node{
+		{ID: "1", Name: "node-1", AdvertiseAddress: "10.0.0.1"},
+	}}
+
+	old := time.Now().Add(-2 * time.Hour)
+	entries := []*Entry{
+		{ClusterID: "cluster-1", Action: ActionCreate, After: created, Timestamp: old},
+		{ClusterID: "cluster-1", Action: ActionRegister, Before: created, After: registered},
+		{ClusterID: "cluster-2", Action: ActionCreate, After: &types.Cluster{ID: "cluster-2"}},
+		{ClusterID: "cluster-1", Action: ActionDelete, Actor: Actor{IP: "10.1.1.1"}, Before: registered},
+	}
+	for _, e := range entries {
+		if err := l.Record(ctx, e); err != nil {
+			t.Fatalf("failed to record entry: %s", err)
+		}
+	}
+
+	history, err := l.History(ctx, "cluster-1")
+	if err != nil {
+		t.Fatalf("failed to get history: %s", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 history entries, got %d", len(history))
+	}
+	if history[0].Action != ActionCreate || history[2].Action != ActionDelete {
+		t.Errorf("unexpected history order: %s, %s", history[0].Action, history[2].Action)
+	}
+	if len(history[1].Changes) != 1 || history[1].Changes[0].Field != "nodes" {
+		t.Errorf("unexpected register changes: %+v", history[1].Changes)
+	}
+
+	deletes, err := l.Query(ctx, Query{Action: ActionDelete, ActorIP: "10.1.1.1"})
+	if err != nil {
+		t.Fatalf("failed to query: %s", err)
+	}
+	if len(deletes) != 1 || deletes[0].ClusterID != "cluster-1" {
+		t.Errorf("unexpected delete query result: %+v", deletes)
+	}
+
+	pruned, err := l.Prune(time.Now())
+	if err != nil {
+		t.Fatalf("failed to prune: %s", err)
+	}
+	if pruned != 1 {
+		t.Errorf("expected 1 entry to be pruned, got %d", pruned)
+	}
+}
@@ -0,0 +1,294 @@
+// Package bootstrap renders cluster membership into configuration consumed
+// by clustered software, e.g. etcd's --initial-cluster flag.
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/storageos/discovery/types"
+)
+
+// errors
+var (
+	ErrUnknownFormat = errors.New("unknown config format")
+	ErrFormatExists  = errors.New("config format already exists")
+)
+
+// built-in formats
+const (
+	FormatEtcd       = "etcd"
+	FormatMemberlist = "memberlist"
+	FormatEnv        = "env"
+	FormatHosts      = "hosts"
+	FormatJSON       = "json"
+)
+
+// TemplateExt - extension of operator supplied templates, the rest of the
+// file name is the format name
+const TemplateExt = ".tmpl"
+
+// PeerEndpoint - name of the node endpoint used for peer URLs, nodes
+// without it use their advertise address
+const PeerEndpoint = "peer"
+
+// Config - cluster membership passed to formats and templates
+type Config struct {
+	ClusterID string             `json:"clusterID"`
+	Name      string             `json:"name,omitempty"`
+	Size      int                `json:"size"`
+	Phase     types.ClusterPhase `json:"phase,omitempty"`
+	// node ID of the bootstrap leader
+	Leader  string   `json:"leader,omitempty"`
+	Members []Member `json:"members"`
+
+	// Cluster is the source cluster, for templates needing other fields
+	Cluster *types.Cluster `json:"-"`
+}
+
+// Member - cluster member
+type Member struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Host and Port of the address, port is empty when not set
+	Host      string            `json:"host"`
+	Port      string            `json:"port,omitempty"`
+	PeerURL   string            `json:"peerURL"`
+	Endpoints map[string]string `json:"endpoints,omitempty"`
+	Leader    bool              `json:"leader,omitempty"`
+}
+
+// NewConfig - config of the cluster, members are in registration order
+func NewConfig(cluster *types.Cluster) *Config {
+	c := &Config{
+		ClusterID: cluster.ID,
+		Name:      cluster.Name,
+		Size:      cluster.Size,
+		Phase:     cluster.Phase,
+		Members:   make([]Member, 0, len(cluster.Nodes)),
+		Cluster:   cluster,
+	}
+	if cluster.Leader != nil {
+		c.Leader = cluster.Leader.NodeID
+	}
+
+	for _, n := range cluster.Nodes {
+		host, port := SplitAddress(n.AdvertiseAddress)
+		m := Member{
+			ID:      n.ID,
+			Name:    n.Name,
+			Address: n.AdvertiseAddress,
+			Host:    host,
+			Port:    port,
+			PeerURL: n.AdvertiseAddress,
+			Leader:  c.Leader != "" && (n.ID == c.Leader || n.ID == "" && n.Name == c.Leader),
+		}
+		if len(n.Endpoints) > 0 {
+			m.Endpoints = make(map[string]string, len(n.Endpoints))
+			for _, e := range n.Endpoints {
+				m.Endpoints[e.Name] = e.URL
+			}
+			if u, ok := m.Endpoints[PeerEndpoint]; ok {
+				m.PeerURL = u
+			}
+		}
+		c.Members = append(c.Members, m)
+	}
+	return c
+}
+
+// SplitAddress - host and port of an advertise address, e.g.
+// http://10.0.0.1:2380, [fe80::1]:2380 or node-1
+func SplitAddress(address string) (host, port string) {
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err == nil {
+			return u.Hostname(), u.Port()
+		}
+	}
+	if h, p, err := net.SplitHostPort(address); err == nil {
+		return h, p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), ""
+}
+
+// Renderer - renders cluster config in built-in and operator supplied
+// formats
+type Renderer struct {
+	templates map[string]*template.Template
+}
+
+// New - create new renderer with optional operator supplied templates
+func New(templates map[string]*template.Template) (*Renderer, error) {
+	for name := range templates {
+		if builtin(name) {
+			return nil, fmt.Errorf("%w: %s is a built-in format", ErrFormatExists, name)
+		}
+	}
+	return &Renderer{templates: templates}, nil
+}
+
+// LoadTemplates - parses every *.tmpl file in dir, templates are named
+// after the file without extension
+func LoadTemplates(dir string) (map[string]*template.Template, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != TemplateExt {
+			continue
+		}
+		name := strings.TrimSuffix(info.Name(), TemplateExt)
+
+		bts, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(name).Funcs(Funcs).Parse(string(bts))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %s", info.Name(), err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// Funcs - functions available to operator supplied templates
+var Funcs = template.FuncMap{
+	"join":  strings.Join,
+	"quote": shellQuote,
+}
+
+func builtin(format string) bool {
+	switch format {
+	case FormatEtcd, FormatMemberlist, FormatEnv, FormatHosts, FormatJSON:
+		return true
+	}
+	return false
+}
+
+// Formats - names of all available formats, sorted
+func (r *Renderer) Formats() []string {
+	formats := []string{FormatEtcd, FormatMemberlist, FormatEnv, FormatHosts, FormatJSON}
+	for name := range r.templates {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// ContentType - content type of the rendered format
+func (r *Renderer) ContentType(format string) string {
+	if format == FormatJSON {
+		return "application/json"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Render - writes cluster config in the given format to w
+func (r *Renderer) Render(w io.Writer, format string, cluster *types.Cluster) error {
+	c := NewConfig(cluster)
+
+	switch format {
+	case FormatEtcd:
+		_, err := fmt.Fprintln(w, Etcd(c))
+		return err
+	case FormatMemberlist:
+		_, err := fmt.Fprintln(w, Memberlist(c))
+		return err
+	case FormatEnv:
+		return Env(w, c)
+	case FormatHosts:
+		return Hosts(w, c)
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(c)
+	}
+
+	tmpl, ok := r.templates[format]
+	if !ok {
+		return fmt.Errorf("%w: %q, expected one of %s", ErrUnknownFormat, format, strings.Join(r.Formats(), ", "))
+	}
+	return tmpl.Execute(w, c)
+}
+
+// Etcd - value of etcd's --initial-cluster flag, e.g.
+// node-1=http://10.0.0.1:2380,node-2=http://10.0.0.2:2380
+func Etcd(c *Config) string {
+	pairs := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		pairs = append(pairs, m.Name+"="+m.PeerURL)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Memberlist - comma separated host:port seed list
+func Memberlist(c *Config) string {
+	seeds := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		if m.Port == "" {
+			seeds = append(seeds, m.Host)
+			continue
+		}
+		seeds = append(seeds, net.JoinHostPort(m.Host, m.Port))
+	}
+	return strings.Join(seeds, ",")
+}
+
+// Env - shell compatible environment variables
+func Env(w io.Writer, c *Config) error {
+	names := make([]string, 0, len(c.Members))
+	addresses := make([]string, 0, len(c.Members))
+	for _, m := range c.Members {
+		names = append(names, m.Name)
+		addresses = append(addresses, m.Address)
+	}
+
+	vars := [][2]string{
+		{"CLUSTER_ID", c.ClusterID},
+		{"CLUSTER_NAME", c.Name},
+		{"CLUSTER_SIZE", fmt.Sprintf("%d", c.Size)},
+		{"CLUSTER_PHASE", string(c.Phase)},
+		{"CLUSTER_LEADER", c.Leader},
+		{"CLUSTER_MEMBERS", strings.Join(names, ",")},
+		{"CLUSTER_ADDRESSES", strings.Join(addresses, ",")},
+		{"CLUSTER_INITIAL_CLUSTER", Etcd(c)},
+		{"CLUSTER_SEEDS", Memberlist(c)},
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", v[0], shellQuote(v[1])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hosts - /etc/hosts entries, members advertising a hostname instead of an
+// IP address are left out
+func Hosts(w io.Writer, c *Config) error {
+	for _, m := range c.Members {
+		if net.ParseIP(m.Host) == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", m.Host, m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,110 @@
+package bootstrap
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/storageos/discovery/types"
+)
+
+func testCluster() *types.Cluster {
+	return &types.Cluster{
+		ID:     "cluster-1",
+		Size:   3,
+		Phase:  types.ClusterPhaseComplete,
+		Leader: &types.Leader{NodeID: "1", Epoch: 1},
+		Nodes: []*types.Node{
+			{ID: "1", Name: "node-1", AdvertiseAddress: "http://10.0.0.1:5705", Endpoints: []types.Endpoint{{Name: "peer", URL: "http://10.0.0.1:2380"}}},
+			{ID: "2", Name: "node-2", AdvertiseAddress: "[fd00::2]:2380"},
+			{ID: "3", Name: "node-3", AdvertiseAddress: "node-3.example.com"},
+		},
+	}
+}
+
+func TestRender(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatalf("failed to create renderer: %s", err)
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatEtcd, "node-1=http://10.0.0.1:2380,node-2=[fd00::2]:2380,node-3=node-3.example.com\n"},
+		{FormatMemberlist, "10.0.0.1:5705,[fd00::2]:2380,node-3.example.com\n"},
+		{FormatHosts, "10.0.0.1\tnode-1\nfd00::2\tnode-2\n"},
+		{FormatEnv, `CLUSTER_ID='cluster-1'
+CLUSTER_NAME=''
+CLUSTER_SIZE='3'
+CLUSTER_PHASE='Complete'
+CLUSTER_LEADER='1'
+CLUSTER_MEMBERS='node-1,node-2,node-3'
+CLUSTER_ADDRESSES='http://10.0.0.1:5705,[fd00::2]:2380,node-3.example.com'
+CLUSTER_INITIAL_CLUSTER='node-1=http://10.0.0.1:2380,node-2=[fd00::2]:2380,node-3=node-3.example.com'
+CLUSTER_SEEDS='10.0.0.1:5705,[fd00::2]:2380,node-3.example.com'
+`},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := r.Render(&buf, tt.format, testCluster()); err != nil {
+			t.Errorf("%s: failed to render: %s", tt.format, err)
+			continue
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.format, buf.String(), tt.want)
+		}
+	}
+
+	err = r.Render(&bytes.Buffer{}, "unknown", testCluster())
+	if !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("expected ErrUnknownFormat, got: %v", err)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testtemplates")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl := `{{range .Members}}{{if .Leader}}{{.Name}} {{.Host}}{{end}}{{end}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "leader.tmpl"), []byte(tmpl), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("ignored"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("failed to load templates: %s", err)
+	}
+	if len(templates) != 1 {
+		t.Fatalf("expected a single template, got %d", len(templates))
+	}
+
+	r, err := New(templates)
+	if err != nil {
+		t.Fatalf("failed to create renderer: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Render(&buf, "leader", testCluster()); err != nil {
+		t.Fatalf("failed to render: %s", err)
+	}
+	if buf.String() != "node-1 10.0.0.1" {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+
+	if _, err := New(map[string]*template.Template{FormatEtcd: templates["leader"]}); !errors.Is(err, ErrFormatExists) {
+		t.Errorf("expected ErrFormatExists, got: %v", err)
+	}
+}
@@ -2,12 +2,17 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
+	"github.com/storageos/discovery/tracing"
 	"github.com/storageos/discovery/types"
 )
 
@@ -18,54 +23,146 @@ const DefaultEndpoint = "https://discovery.storageos.cloud"
 type Client interface {
 	ClusterGet(ref string) (*types.Cluster, error)
 	ClusterRegisterNode(clusterID, nodeID, name, advertiseIP string) (*types.Cluster, error)
+	ClusterRegister(clusterID string, node *types.Node) (*types.Cluster, error)
+	ClusterRegisterNodes(clusterID string, nodes []*types.Node) (*types.NodeBatchResult, error)
 }
 
-// DefaultClient - default discovery client
+// DefaultClient - default discovery client. Methods ending with Context
+// send their request with the given context, its deadline applies and its
+// span is continued when the client traces requests.
 type DefaultClient struct {
-	endpoint string
-	client   *http.Client
+	endpoint   string
+	adminToken string
+	client     *http.Client
+
+	// clusters fetched by ClusterGet, revalidated on the next get
+	cacheDisabled bool
+	mu            sync.Mutex
+	cache         map[string]*cachedCluster
+}
+
+type cachedCluster struct {
+	etag         string
+	lastModified string
+	body         []byte
 }
 
 // New - create new discovery client
 func New(options ...Option) *DefaultClient {
-	client := DefaultClient{
+	client := &DefaultClient{
 		endpoint: DefaultEndpoint,
 		client:   &http.Client{},
+		cache:    make(map[string]*cachedCluster),
 	}
 
 	for _, opt := range options {
-		opt.Configure(&client)
+		opt.Configure(client)
 	}
 
-	return &client
+	return client
 }
 
-// ClusterGet - get specified cluster by ID
+// ClusterGet - get specified cluster by ID, previously fetched clusters
+// are revalidated and only downloaded again when they changed
 func (c *DefaultClient) ClusterGet(ref string) (*types.Cluster, error) {
-	req, err := http.NewRequest("GET", c.endpoint+"/clusters/"+ref, nil)
+	return c.ClusterGetContext(context.Background(), ref)
+}
+
+// ClusterGetContext - like ClusterGet, sends the request with ctx
+func (c *DefaultClient) ClusterGetContext(ctx context.Context, ref string) (*types.Cluster, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint+"/clusters/"+ref, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	cached := c.cached(ref)
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	var body []byte
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		c.store(ref, resp.Header, body)
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		body = cached.body
+	default:
+		if resp.StatusCode == http.StatusNotFound {
+			c.evict(ref)
+		}
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
 	var cluster types.Cluster
-	if err := json.NewDecoder(resp.Body).Decode(&cluster); err != nil {
+	if err := json.Unmarshal(body, &cluster); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response from discovery service: %s", err)
 	}
 
 	return &cluster, nil
 }
 
-// ClusterCreate - create cluster
+func (c *DefaultClient) cached(ref string) *cachedCluster {
+	if c.cacheDisabled {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cache[ref]
+}
+
+func (c *DefaultClient) store(ref string, header http.Header, body []byte) {
+	if c.cacheDisabled {
+		return
+	}
+
+	entry := &cachedCluster{
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		body:         body,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry.etag == "" && entry.lastModified == "" {
+		delete(c.cache, ref)
+		return
+	}
+	c.cache[ref] = entry
+}
+
+func (c *DefaultClient) evict(ref string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, ref)
+}
+
+// ClusterCreate - create cluster, tokens of reserved slots are only
+// returned by this call
 func (c *DefaultClient) ClusterCreate(opts types.ClusterCreateOps) (*types.Cluster, error) {
+	return c.ClusterCreateContext(context.Background(), opts)
+}
+
+// ClusterCreateContext - like ClusterCreate, sends the request with ctx
+func (c *DefaultClient) ClusterCreateContext(ctx context.Context, opts types.ClusterCreateOps) (*types.Cluster, error) {
 
 	path := c.endpoint + "/clusters"
 	vals := url.Values{}
@@ -73,10 +170,22 @@ func (c *DefaultClient) ClusterCreate(opts types.ClusterCreateOps) (*types.Clust
 	vals.Set("name", fmt.Sprintf("%s", opts.Name))
 	path = fmt.Sprintf("%s?%s", path, vals.Encode())
 
-	req, err := http.NewRequest("POST", path, nil)
+	var body io.Reader
+	if len(opts.Reservations) > 0 {
+		reqBody, err := json.Marshal(map[string]interface{}{"reservations": opts.Reservations})
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(reqBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", path, body)
 	if err != nil {
 		return nil, err
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -102,21 +211,224 @@ func (c *DefaultClient) ClusterCreate(opts types.ClusterCreateOps) (*types.Clust
 
 // ClusterRegisterNode - register node to cluster
 func (c *DefaultClient) ClusterRegisterNode(clusterID, nodeID, name, advertiseAddress string) (*types.Cluster, error) {
-	node := types.Node{
+	return c.ClusterRegisterNodeContext(context.Background(), clusterID, nodeID, name, advertiseAddress)
+}
+
+// ClusterRegisterNodeContext - like ClusterRegisterNode, sends the request with ctx
+func (c *DefaultClient) ClusterRegisterNodeContext(ctx context.Context, clusterID, nodeID, name, advertiseAddress string) (*types.Cluster, error) {
+	return c.ClusterRegisterContext(ctx, clusterID, &types.Node{
 		ID:               nodeID,
 		Name:             name,
 		AdvertiseAddress: advertiseAddress,
+	})
+}
+
+// ClusterRegister - register node together with its metadata (labels,
+// role, zone, region and version) to cluster
+func (c *DefaultClient) ClusterRegister(clusterID string, node *types.Node) (*types.Cluster, error) {
+	return c.ClusterRegisterContext(context.Background(), clusterID, node)
+}
+
+// ClusterRegisterContext - like ClusterRegister, sends the request with ctx
+func (c *DefaultClient) ClusterRegisterContext(ctx context.Context, clusterID string, node *types.Node) (*types.Cluster, error) {
+	reqBody, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", c.endpoint+"/clusters/"+clusterID, ioutil.NopCloser(bytes.NewBuffer(reqBody)))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respMsg, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected status code: %d, response body unavailable", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(respMsg))
+	}
+
+	var cluster types.Cluster
+	if err := json.NewDecoder(resp.Body).Decode(&cluster); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response from discovery service: %s", err)
+	}
+
+	return &cluster, nil
+}
+
+// ClusterRegisterNodes - registers all nodes to the cluster in one request,
+// either all of them or none. Results are returned also when the batch is
+// rejected, together with an error.
+func (c *DefaultClient) ClusterRegisterNodes(clusterID string, nodes []*types.Node) (*types.NodeBatchResult, error) {
+	return c.ClusterRegisterNodesContext(context.Background(), clusterID, nodes)
+}
+
+// ClusterRegisterNodesContext - like ClusterRegisterNodes, sends the request with ctx
+func (c *DefaultClient) ClusterRegisterNodesContext(ctx context.Context, clusterID string, nodes []*types.Node) (*types.NodeBatchResult, error) {
+	reqBody, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/clusters/"+clusterID+"/nodes:batch", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected status code: %d, response body unavailable", resp.StatusCode)
+	}
+
+	// rejected batches are answered with the results, slot errors with
+	// the same codes as single registrations
+	rejected := false
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnprocessableEntity, http.StatusForbidden, http.StatusConflict:
+		rejected = true
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(respBody))
+	}
+
+	var result types.NodeBatchResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response from discovery service: %s", err)
+	}
+
+	if rejected {
+		return &result, fmt.Errorf("node batch rejected: %s", rejections(result.Results))
+	}
+	return &result, nil
+}
+
+// rejections - describes rejected nodes of a batch
+func rejections(results []types.NodeResult) string {
+	var msgs []string
+	for _, r := range results {
+		if r.Status == types.NodeResultRejected {
+			msgs = append(msgs, fmt.Sprintf("node %d (%s): %s", r.Index, r.Name, r.Error))
+		}
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// ClusterUpdateNode - update address, name or metadata of a registered
+// node, e.g. after it got a new address
+func (c *DefaultClient) ClusterUpdateNode(clusterID, nodeID string, update *types.NodeUpdate) (*types.Cluster, error) {
+	return c.ClusterUpdateNodeContext(context.Background(), clusterID, nodeID, update)
+}
+
+// ClusterUpdateNodeContext - like ClusterUpdateNode, sends the request with ctx
+func (c *DefaultClient) ClusterUpdateNodeContext(ctx context.Context, clusterID, nodeID string, update *types.NodeUpdate) (*types.Cluster, error) {
+	reqBody, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.doNode(ctx, "PATCH", clusterID, nodeID, "", bytes.NewBuffer(reqBody))
+}
+
+// ClusterUpdate - changes name, size or TTL of the cluster, version 0
+// updates unconditionally
+func (c *DefaultClient) ClusterUpdate(clusterID string, update *types.ClusterUpdate, version uint64) (*types.Cluster, error) {
+	return c.ClusterUpdateContext(context.Background(), clusterID, update, version)
+}
+
+// ClusterUpdateContext - like ClusterUpdate, sends the request with ctx
+func (c *DefaultClient) ClusterUpdateContext(ctx context.Context, clusterID string, update *types.ClusterUpdate, version uint64) (*types.Cluster, error) {
+	reqBody, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PATCH", c.endpoint+"/clusters/"+clusterID, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, version))
+	}
+	return c.doCluster(req)
+}
+
+// ClusterHeartbeat - reports node as alive
+func (c *DefaultClient) ClusterHeartbeat(clusterID, nodeID string) (*types.Cluster, error) {
+	return c.ClusterHeartbeatContext(context.Background(), clusterID, nodeID)
+}
+
+// ClusterHeartbeatContext - like ClusterHeartbeat, sends the request with ctx
+func (c *DefaultClient) ClusterHeartbeatContext(ctx context.Context, clusterID, nodeID string) (*types.Cluster, error) {
+	return c.doNode(ctx, "POST", clusterID, nodeID, "/heartbeat", nil)
+}
+
+// ClusterDeregisterNode - removes node from the cluster
+func (c *DefaultClient) ClusterDeregisterNode(clusterID, nodeID string) (*types.Cluster, error) {
+	return c.ClusterDeregisterNodeContext(context.Background(), clusterID, nodeID)
+}
+
+// ClusterDeregisterNodeContext - like ClusterDeregisterNode, sends the request with ctx
+func (c *DefaultClient) ClusterDeregisterNodeContext(ctx context.Context, clusterID, nodeID string) (*types.Cluster, error) {
+	return c.doNode(ctx, "DELETE", clusterID, nodeID, "", nil)
+}
+
+// ClusterConfirmLeader - bootstrap leader confirms it initialized the
+// cluster
+func (c *DefaultClient) ClusterConfirmLeader(clusterID, nodeID string, epoch uint64) (*types.Cluster, error) {
+	return c.ClusterConfirmLeaderContext(context.Background(), clusterID, nodeID, epoch)
+}
+
+// ClusterConfirmLeaderContext - like ClusterConfirmLeader, sends the request with ctx
+func (c *DefaultClient) ClusterConfirmLeaderContext(ctx context.Context, clusterID, nodeID string, epoch uint64) (*types.Cluster, error) {
+	return c.doLeader(ctx, clusterID, "confirm", nodeID, epoch)
+}
+
+// ClusterHandoverLeader - passes bootstrap leadership to the next node
+func (c *DefaultClient) ClusterHandoverLeader(clusterID, nodeID string, epoch uint64) (*types.Cluster, error) {
+	return c.ClusterHandoverLeaderContext(context.Background(), clusterID, nodeID, epoch)
+}
+
+// ClusterHandoverLeaderContext - like ClusterHandoverLeader, sends the request with ctx
+func (c *DefaultClient) ClusterHandoverLeaderContext(ctx context.Context, clusterID, nodeID string, epoch uint64) (*types.Cluster, error) {
+	return c.doLeader(ctx, clusterID, "handover", nodeID, epoch)
+}
+
+func (c *DefaultClient) doLeader(ctx context.Context, clusterID, action, nodeID string, epoch uint64) (*types.Cluster, error) {
+	reqBody, err := json.Marshal(&types.LeaderRequest{NodeID: nodeID, Epoch: epoch})
+	if err != nil {
+		return nil, err
 	}
-	reqBody, err := json.Marshal(&node)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/clusters/"+clusterID+"/leader/"+action, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, err
 	}
+	return c.doCluster(req)
+}
 
-	req, err := http.NewRequest("PUT", c.endpoint+"/clusters/"+clusterID, ioutil.NopCloser(bytes.NewBuffer(reqBody)))
+func (c *DefaultClient) doNode(ctx context.Context, method, clusterID, nodeID, suffix string, body io.Reader) (*types.Cluster, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+"/clusters/"+clusterID+"/nodes/"+url.PathEscape(nodeID)+suffix, body)
 	if err != nil {
 		return nil, err
 	}
+	return c.doCluster(req)
+}
 
+// doCluster - sends request responded to with the updated cluster
+func (c *DefaultClient) doCluster(req *http.Request) (*types.Cluster, error) {
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -139,6 +451,73 @@ func (c *DefaultClient) ClusterRegisterNode(clusterID, nodeID, name, advertiseAd
 	return &cluster, nil
 }
 
+// Snapshot - streams consistent snapshot of the discovery database into w,
+// requires admin token
+func (c *DefaultClient) Snapshot(w io.Writer) (int64, error) {
+	return c.SnapshotContext(context.Background(), w)
+}
+
+// SnapshotContext - like Snapshot, sends the request with ctx
+func (c *DefaultClient) SnapshotContext(ctx context.Context, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint+"/admin/snapshot", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.doAdmin(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return io.Copy(w, resp.Body)
+}
+
+// Restore - replaces discovery database with the snapshot read from r,
+// requires admin token
+func (c *DefaultClient) Restore(r io.Reader) error {
+	return c.RestoreContext(context.Background(), r)
+}
+
+// RestoreContext - like Restore, sends the request with ctx
+func (c *DefaultClient) RestoreContext(ctx context.Context, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+"/admin/restore", r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := c.doAdmin(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// doAdmin - sends authenticated admin request, response body is closed
+// unless status code is 200
+func (c *DefaultClient) doAdmin(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+c.adminToken)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respMsg, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected status code: %d, response body unavailable", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected status code: %d (%s)", resp.StatusCode, string(respMsg))
+	}
+
+	return resp, nil
+}
+
 // WithEndpoint - override default endpoint
 func WithEndpoint(endpoint string) Option {
 	return OptionFn(func(c *DefaultClient) error {
@@ -147,6 +526,33 @@ func WithEndpoint(endpoint string) Option {
 	})
 }
 
+// WithAdminToken - token used to authenticate admin requests
+func WithAdminToken(token string) Option {
+	return OptionFn(func(c *DefaultClient) error {
+		c.adminToken = token
+		return nil
+	})
+}
+
+// WithoutCache - always download clusters instead of revalidating
+// previously fetched ones
+func WithoutCache() Option {
+	return OptionFn(func(c *DefaultClient) error {
+		c.cacheDisabled = true
+		return nil
+	})
+}
+
+// WithTracer - traces requests, the traceparent header is sent so server
+// spans join the client's trace. Requests of the Context methods continue
+// the span of their context.
+func WithTracer(t *tracing.Tracer) Option {
+	return OptionFn(func(c *DefaultClient) error {
+		c.client.Transport = &tracing.Transport{Tracer: t, Base: c.client.Transport}
+		return nil
+	})
+}
+
 // Option is used to pass optional arguments to
 // the DefaultRecorder constructor
 type Option interface {
@@ -1,13 +1,21 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"time"
 
 	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/handlers"
 	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/tracing"
 	"github.com/storageos/discovery/types"
 	"github.com/storageos/discovery/util/codecs"
 
@@ -16,12 +24,17 @@ import (
 
 const testServerPort = 4551
 const testServerEndpoint = "http://127.0.0.1:4551"
+const testAdminToken = "test-admin-token"
 
 func TestMain(m *testing.M) {
 
 	srv := newTestingServer()
 	go srv.Start()
 
+	if err := waitForServer("127.0.0.1:4551", 5*time.Second); err != nil {
+		log.Fatalf("test server didn't start: %s", err)
+	}
+
 	retCode := m.Run()
 
 	srv.Stop()
@@ -42,10 +55,24 @@ func newTestingServer() *handlers.Server {
 
 	cm := cluster.New(db, codecs.DefaultSerializer())
 
-	srv := handlers.NewServer(testServerPort, cm)
+	srv := handlers.NewServer(testServerPort, cm, handlers.WithAdminToken(testAdminToken))
 	return srv
 }
 
+func waitForServer(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			return conn.Close()
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestClientRegister(t *testing.T) {
 	client := New(WithEndpoint(testServerEndpoint))
 
@@ -71,3 +98,191 @@ func TestClientRegister(t *testing.T) {
 		t.Errorf("unexpected advertise address: %s", cluster.Nodes[0].AdvertiseAddress)
 	}
 }
+
+func TestClientRegisterNodes(t *testing.T) {
+	client := New(WithEndpoint(testServerEndpoint))
+
+	newCluster, err := client.ClusterCreate(types.ClusterCreateOps{Name: "batch", Size: 2})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+
+	result, err := client.ClusterRegisterNodes(newCluster.ID, []*types.Node{
+		{ID: "1", Name: "node-1", AdvertiseAddress: "1.1.1.1"},
+		{ID: "2", Name: "node-2"},
+	})
+	if err == nil {
+		t.Fatalf("expected batch with invalid node to be rejected")
+	}
+	if result == nil || result.Results[1].Status != types.NodeResultRejected {
+		t.Fatalf("expected results of the rejected batch, got %+v", result)
+	}
+
+	result, err = client.ClusterRegisterNodes(newCluster.ID, []*types.Node{
+		{ID: "1", Name: "node-1", AdvertiseAddress: "1.1.1.1"},
+		{ID: "2", Name: "node-2", AdvertiseAddress: "1.1.1.2"},
+	})
+	if err != nil {
+		t.Fatalf("failed to register nodes: %s", err)
+	}
+	if len(result.Cluster.Nodes) != 2 || result.Cluster.Phase != types.ClusterPhaseComplete {
+		t.Errorf("unexpected cluster: %+v", result.Cluster)
+	}
+}
+
+func TestClientReservedSlots(t *testing.T) {
+	client := New(WithEndpoint(testServerEndpoint))
+
+	newCluster, err := client.ClusterCreate(types.ClusterCreateOps{Name: "reserved", Size: 1, Reservations: []types.Reservation{{RequireToken: true}}})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+	if len(newCluster.Reservations) != 1 || newCluster.Reservations[0].Token == "" {
+		t.Fatalf("expected slot token, got %+v", newCluster.Reservations)
+	}
+
+	node := &types.Node{ID: "1", Name: "node-1", AdvertiseAddress: "1.1.1.1"}
+	if _, err := client.ClusterRegister(newCluster.ID, node); err == nil {
+		t.Errorf("expected node without token to be rejected")
+	}
+
+	node.SlotToken = newCluster.Reservations[0].Token
+	cluster, err := client.ClusterRegister(newCluster.ID, node)
+	if err != nil {
+		t.Fatalf("failed to register node: %s", err)
+	}
+	if cluster.Reservations[0].NodeID != node.ID {
+		t.Errorf("expected node to hold the reserved slot: %+v", cluster.Reservations)
+	}
+}
+
+func TestClientSnapshotRestore(t *testing.T) {
+	client := New(WithEndpoint(testServerEndpoint), WithAdminToken(testAdminToken))
+
+	before, err := client.ClusterCreate(types.ClusterCreateOps{Name: "snapshot-1", Size: 3})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := client.Snapshot(buf)
+	if err != nil {
+		t.Fatalf("failed to take snapshot: %s", err)
+	}
+	if n == 0 || int64(buf.Len()) != n {
+		t.Fatalf("unexpected snapshot size: %d (buffer %d)", n, buf.Len())
+	}
+
+	after, err := client.ClusterCreate(types.ClusterCreateOps{Name: "snapshot-2", Size: 3})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+
+	if err := client.Restore(buf); err != nil {
+		t.Fatalf("failed to restore snapshot: %s", err)
+	}
+
+	if _, err := client.ClusterGet(before.ID); err != nil {
+		t.Errorf("expected cluster %s to be restored: %s", before.ID, err)
+	}
+
+	if _, err := client.ClusterGet(after.ID); err == nil {
+		t.Errorf("expected cluster %s created after snapshot to be gone", after.ID)
+	}
+
+	if err := client.Restore(bytes.NewBufferString("not a database")); err == nil {
+		t.Errorf("expected invalid snapshot to be rejected")
+	}
+}
+
+func TestClientSnapshotUnauthorized(t *testing.T) {
+	client := New(WithEndpoint(testServerEndpoint), WithAdminToken("wrong"))
+
+	if _, err := client.Snapshot(&bytes.Buffer{}); err == nil {
+		t.Errorf("expected snapshot with invalid token to fail")
+	}
+}
+
+// statusRecorder - records status codes of responses
+type statusRecorder struct {
+	codes []int
+}
+
+func (s *statusRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		s.codes = append(s.codes, resp.StatusCode)
+	}
+	return resp, err
+}
+
+func TestClientClusterGetCache(t *testing.T) {
+	client := New(WithEndpoint(testServerEndpoint))
+	recorder := &statusRecorder{}
+	client.client.Transport = recorder
+
+	newCluster, err := client.ClusterCreate(types.ClusterCreateOps{Name: "cached", Size: 3})
+	if err != nil {
+		t.Fatalf("failed to create cluster: %s", err)
+	}
+	recorder.codes = nil
+
+	for i := 0; i < 2; i++ {
+		c, err := client.ClusterGet(newCluster.ID)
+		if err != nil {
+			t.Fatalf("failed to get cluster: %s", err)
+		}
+		if c.ID != newCluster.ID || c.Name != "cached" {
+			t.Errorf("unexpected cluster: %+v", c)
+		}
+	}
+
+	if _, err := client.ClusterRegisterNode(newCluster.ID, "node-1", "node-1", "1.1.1.10"); err != nil {
+		t.Fatalf("failed to register node: %s", err)
+	}
+
+	c, err := client.ClusterGet(newCluster.ID)
+	if err != nil {
+		t.Fatalf("failed to get cluster: %s", err)
+	}
+	if len(c.Nodes) != 1 {
+		t.Errorf("expected changed cluster to be downloaded again, got %d nodes", len(c.Nodes))
+	}
+
+	expected := []int{http.StatusOK, http.StatusNotModified, http.StatusOK, http.StatusOK}
+	if fmt.Sprint(recorder.codes) != fmt.Sprint(expected) {
+		t.Errorf("expected status codes %v, got %v", expected, recorder.codes)
+	}
+}
+
+func TestClientTracePropagation(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(tracing.HeaderTraceparent)
+		w.Write([]byte(`{"id":"traced"}`))
+	}))
+	defer ts.Close()
+
+	tracer := tracing.New(tracing.NewStdoutExporter(ioutil.Discard))
+	defer tracer.Stop()
+
+	client := New(WithEndpoint(ts.URL), WithoutCache(), WithTracer(tracer))
+
+	ctx, parent := tracer.StartRoot(context.Background(), "parent", tracing.SpanKindInternal, tracing.SpanContext{})
+	defer parent.End()
+
+	if _, err := client.ClusterGetContext(ctx, "traced"); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := tracing.ParseTraceparent(received)
+	if err != nil {
+		t.Fatalf("invalid traceparent %q: %s", received, err)
+	}
+	if sc.TraceID != parent.SpanContext().TraceID {
+		t.Errorf("expected request to continue trace %s, server received %s", parent.SpanContext().TraceID, sc.TraceID)
+	}
+	if sc.SpanID == parent.SpanContext().SpanID {
+		t.Errorf("expected the client span to be sent, not the parent")
+	}
+}
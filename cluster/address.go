@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AddressError - describes why advertise address was rejected
+type AddressError struct {
+	Address string
+	Reason  string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("%s %q: %s", ErrInvalidAddress, e.Address, e.Reason)
+}
+
+// Unwrap - address errors match ErrInvalidAddress
+func (e *AddressError) Unwrap() error {
+	return ErrInvalidAddress
+}
+
+var (
+	schemeRegexp = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*$`)
+	labelRegexp  = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeAddress - validates advertise address and returns its canonical
+// form. Accepted formats are:
+//
+//	scheme://host[:port][/]  e.g. http://10.0.0.1:2380
+//	IPv4 address with optional port, e.g. 10.0.0.1 or 10.0.0.1:2380
+//	IPv6 address with optional port, e.g. fe80::1 or [fe80::1]:2380
+//	hostname with optional port, e.g. node-1.example.com:2380
+//
+// Canonical form has lower case scheme and host, IPv6 addresses in their
+// shortest form, no trailing slash and no default http/https port.
+// Loopback and unspecified addresses are rejected unless allowLoopback is
+// set.
+func NormalizeAddress(address string, allowLoopback bool) (string, error) {
+	invalid := func(format string, args ...interface{}) (string, error) {
+		return "", &AddressError{Address: address, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if strings.TrimSpace(address) != address {
+		return invalid("leading or trailing whitespace")
+	}
+
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil {
+			return invalid("malformed URL")
+		}
+		if !schemeRegexp.MatchString(u.Scheme) {
+			return invalid("invalid scheme")
+		}
+		if u.User != nil {
+			return invalid("user info is not allowed")
+		}
+		if u.RawQuery != "" || u.Fragment != "" || u.Opaque != "" {
+			return invalid("query and fragment are not allowed")
+		}
+		if u.Path != "" && u.Path != "/" {
+			return invalid("path is not allowed")
+		}
+
+		scheme := strings.ToLower(u.Scheme)
+		host, port, err := normalizeHostPort(u.Host, allowLoopback)
+		if err != nil {
+			return invalid("%s", err)
+		}
+		if port == defaultPorts[scheme] {
+			port = ""
+		}
+		if port == "" && strings.Contains(host, ":") {
+			// IPv6 hosts are always bracketed in URLs
+			return scheme + "://[" + host + "]", nil
+		}
+		return scheme + "://" + joinHostPort(host, port), nil
+	}
+
+	if strings.ContainsAny(address, "/?#@") {
+		return invalid("expected scheme://host:port, IP address or hostname")
+	}
+
+	host, port, err := normalizeHostPort(address, allowLoopback)
+	if err != nil {
+		return invalid("%s", err)
+	}
+	return joinHostPort(host, port), nil
+}
+
+// normalizeHostPort - validates host with optional port
+func normalizeHostPort(hostport string, allowLoopback bool) (host, port string, err error) {
+	if hostport == "" {
+		return "", "", fmt.Errorf("host missing")
+	}
+
+	host = hostport
+	if strings.HasPrefix(hostport, "[") && strings.HasSuffix(hostport, "]") {
+		// bracketed IPv6 address without port
+		host = hostport[1 : len(hostport)-1]
+		if net.ParseIP(host) == nil {
+			return "", "", fmt.Errorf("invalid IPv6 address")
+		}
+	} else if ip := net.ParseIP(hostport); ip == nil && strings.Contains(hostport, ":") {
+		// host with port, bare IPv6 addresses were parsed as IPs above
+		host, port, err = net.SplitHostPort(hostport)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid host and port")
+		}
+		if port == "" {
+			return "", "", fmt.Errorf("port missing after colon")
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return "", "", fmt.Errorf("invalid port %s", port)
+		}
+		port = strconv.Itoa(p)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if !allowLoopback && (ip.IsLoopback() || ip.IsUnspecified()) {
+			return "", "", fmt.Errorf("loopback and unspecified addresses are not allowed")
+		}
+		return ip.String(), port, nil
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if err := hostnameValid(host); err != nil {
+		return "", "", err
+	}
+	if !allowLoopback && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return "", "", fmt.Errorf("loopback and unspecified addresses are not allowed")
+	}
+	return host, port, nil
+}
+
+func hostnameValid(host string) error {
+	if len(host) == 0 || len(host) > 253 {
+		return fmt.Errorf("invalid hostname length")
+	}
+	labels := strings.Split(host, ".")
+	for _, label := range labels {
+		if len(label) > 63 || !labelRegexp.MatchString(label) {
+			return fmt.Errorf("invalid hostname")
+		}
+	}
+	// rejects malformed IPv4 addresses such as 10.0.0.256
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return fmt.Errorf("invalid IP address")
+	}
+	return nil
+}
+
+func joinHostPort(host, port string) string {
+	if port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// sameAddress - compares addresses by their canonical form, addresses that
+// can't be normalized are compared as they are
+func sameAddress(a, b string) bool {
+	return canonicalAddress(a) == canonicalAddress(b)
+}
+
+func canonicalAddress(address string) string {
+	normalized, err := NormalizeAddress(address, true)
+	if err != nil {
+		return address
+	}
+	return normalized
+}
@@ -1,97 +1,527 @@
 package cluster
 
 import (
+	"context"
 	"errors"
-	"net/url"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/tracing"
 	"github.com/storageos/discovery/types"
 	"github.com/storageos/discovery/util/codecs"
+	"github.com/storageos/discovery/util/logging"
 	"github.com/storageos/discovery/util/uuid"
 )
 
 // node registration errors
 var (
-	ErrAddressMissing     = errors.New("node address missing")
-	ErrInvalidAddress     = errors.New("invalid node address")
-	ErrNameMissing        = errors.New("node name missing")
-	ErrNodeNamePresent    = errors.New("node name already present")
-	ErrNodeAddressPresent = errors.New("node address already present")
+	ErrAddressMissing      = errors.New("node address missing")
+	ErrInvalidAddress      = errors.New("invalid node address")
+	ErrNameMissing         = errors.New("node name missing")
+	ErrIDMissing           = errors.New("node ID missing")
+	ErrNodeNamePresent     = errors.New("node name already present")
+	ErrNodeAddressPresent  = errors.New("node address already present")
+	ErrNodeNotFound        = errors.New("node not found")
+	ErrNodeEndpointPresent = errors.New("node endpoint already present")
+	ErrBatchEmpty          = errors.New("no nodes to register")
+	ErrBatchRejected       = errors.New("node batch rejected")
 )
 
+// cluster errors
+var (
+	ErrClusterNotDeleted = errors.New("cluster is not deleted")
+	ErrVersionMismatch   = errors.New("cluster version doesn't match")
+	ErrSizeTooSmall      = errors.New("cluster size is below the number of founding nodes")
+	ErrInvalidTTL        = errors.New("invalid cluster TTL")
+)
+
+// quota errors
+var (
+	ErrUnknownAccount = errors.New("unknown account")
+	ErrQuotaExceeded  = errors.New("account quota exceeded")
+)
+
+// QuotaSource - provides per-account quotas enforced when clusters are
+// created or changed
+type QuotaSource interface {
+	Quota(ctx context.Context, accountID string) (*types.Quota, error)
+}
+
+// import errors
+var (
+	ErrClusterIDMissing    = errors.New("cluster ID missing")
+	ErrInvalidSize         = errors.New("invalid cluster size")
+	ErrClusterExists       = errors.New("cluster already exists")
+	ErrUnknownImportPolicy = errors.New("unknown import policy")
+)
+
+// ImportPolicy - what to do when an imported cluster already exists
+type ImportPolicy string
+
+// import policies
+const (
+	// ImportSkip keeps existing cluster
+	ImportSkip ImportPolicy = "skip"
+	// ImportOverwrite replaces existing cluster with the imported one
+	ImportOverwrite ImportPolicy = "overwrite"
+	// ImportFail aborts the whole import without writing anything
+	ImportFail ImportPolicy = "fail"
+)
+
+// ImportReport - IDs of imported clusters partitioned by outcome
+type ImportReport struct {
+	Created     []string `json:"created"`
+	Overwritten []string `json:"overwritten"`
+	Skipped     []string `json:"skipped"`
+}
+
+// ImportError - describes which of the imported clusters was rejected
+type ImportError struct {
+	// Index of the cluster in the import
+	Index     int
+	ClusterID string
+	Err       error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("cluster %d (%s): %s", e.Index, e.ClusterID, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
 // Manager - cluster manager
 type Manager interface {
 	// create new cluster
-	Create(opts types.ClusterCreateOps) (*types.Cluster, error)
+	Create(ctx context.Context, opts types.ClusterCreateOps) (*types.Cluster, error)
 
 	// get cluster by ID
-	Get(ref string) (*types.Cluster, error)
+	Get(ctx context.Context, ref string) (*types.Cluster, error)
+	// get deleted cluster by ID, e.g. to check its owner before restoring it
+	GetDeleted(ctx context.Context, id string) (*types.Cluster, error)
+	// list all clusters
+	List(ctx context.Context) ([]*types.Cluster, error)
+	// list clusters of the account
+	ListAccount(ctx context.Context, accountID string) ([]*types.Cluster, error)
+	// cluster, node and reaper statistics
+	Stats(ctx context.Context) (*Stats, error)
+	// changes of clusters and their nodes return the cluster as it was read
+	// under the cluster lock before the change, and as it was written
+
 	// register node
-	RegisterNode(clusterID string, node *types.Node) (updated *types.Cluster, err error)
+	RegisterNode(ctx context.Context, clusterID string, node *types.Node) (before, updated *types.Cluster, err error)
+	// register several nodes at once, either all of them or none
+	RegisterNodes(ctx context.Context, clusterID string, nodes []*types.Node) (before, updated *types.Cluster, results []types.NodeResult, err error)
+	// update address, name or metadata of a registered node
+	UpdateNode(ctx context.Context, clusterID, nodeID string, update *types.NodeUpdate) (before, updated *types.Cluster, err error)
+	// mark node as alive
+	Heartbeat(ctx context.Context, clusterID, nodeID string) (*types.Cluster, error)
+	// remove node from the cluster
+	DeregisterNode(ctx context.Context, clusterID, nodeID string) (before, updated *types.Cluster, err error)
+	// bootstrap leader confirms initialization
+	ConfirmLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error)
+	// pass bootstrap leadership to the next node
+	HandoverLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error)
 	// update cluster details
-	Update(cluster *types.Cluster) error
-	// delete cluster
-	Delete(id string) error
+	Update(ctx context.Context, cluster *types.Cluster) error
+	// change name, size or TTL, version 0 skips the version check
+	UpdateCluster(ctx context.Context, id string, update *types.ClusterUpdate, version uint64) (before, updated *types.Cluster, err error)
+	// delete cluster, deleted cluster can be restored until the delete
+	// grace period passes
+	Delete(ctx context.Context, id string) (*types.Cluster, error)
+	// restore deleted cluster
+	Undelete(ctx context.Context, id string) (*types.Cluster, error)
+	// delete cluster immediately, without grace period
+	Purge(ctx context.Context, id string) error
+
+	// import clusters, all of them are validated before any is written
+	Import(ctx context.Context, clusters []*types.Cluster, policy ImportPolicy) (*ImportReport, error)
+
+	// write consistent snapshot of all clusters
+	Snapshot(ctx context.Context, w io.Writer) (int64, error)
+	// replace all clusters with a validated snapshot
+	Restore(ctx context.Context, r io.Reader) error
+
+	// receive cluster phase transitions
+	Subscribe(h EventHandler)
 }
 
+// accountLockPrefix - creating clusters of an account locks the stripe of
+// the prefixed account ID, it can't clash with cluster IDs
+const accountLockPrefix = "account/"
+
+// DefaultDeleteGracePeriod - default time deleted clusters can be restored
+const DefaultDeleteGracePeriod = 72 * time.Hour
+
 // DefaultManager - default cluster manager
 type DefaultManager struct {
-	mu         *sync.Mutex
-	store      store.Store
-	serializer codecs.Serializer
+	// first field so the atomic counters are 64-bit aligned on 32-bit
+	// platforms
+	reaper reaperStats
+
+	locks             clusterLocks
+	store             store.Store
+	serializer        codecs.Serializer
+	deleteGracePeriod time.Duration
+	allowLoopback     bool
+	heartbeatTimeout  time.Duration
+	leaderRule        LeaderRule
+	leaderTimeout     time.Duration
+	quotas            QuotaSource
+
+	handlersMu sync.RWMutex
+	handlers   []EventHandler
+
+	stopOnce sync.Once
+	stop     chan struct{}
 }
 
 // New - create new cluster manager
-func New(store store.Store, serializer codecs.Serializer) *DefaultManager {
-	return &DefaultManager{
-		mu:         &sync.Mutex{},
-		store:      store,
-		serializer: serializer,
+func New(store store.Store, serializer codecs.Serializer, options ...Option) *DefaultManager {
+	m := &DefaultManager{
+		store:             store,
+		serializer:        serializer,
+		deleteGracePeriod: DefaultDeleteGracePeriod,
+		leaderRule:        LeaderRuleFirst,
+		leaderTimeout:     DefaultLeaderTimeout,
+		stop:              make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt.Configure(m)
 	}
+
+	return m
+}
+
+// WithDeleteGracePeriod - how long deleted clusters can be restored before
+// they are purged
+func WithDeleteGracePeriod(d time.Duration) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.deleteGracePeriod = d
+		return nil
+	})
 }
 
-// Create - create new cluster
-func (m *DefaultManager) Create(opts types.ClusterCreateOps) (*types.Cluster, error) {
+// WithAllowLoopback - accept loopback and unspecified advertise addresses,
+// e.g. for local development
+func WithAllowLoopback(allow bool) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.allowLoopback = allow
+		return nil
+	})
+}
+
+// WithQuotas - enforces account quotas on clusters created with an account
+// ID
+func WithQuotas(q QuotaSource) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.quotas = q
+		return nil
+	})
+}
+
+// Option is used to pass optional arguments to
+// the DefaultManager constructor
+type Option interface {
+	Configure(*DefaultManager) error
+}
+
+// OptionFn is a type of Option that is represented
+// by a single function that gets called for Configure()
+type OptionFn func(*DefaultManager) error
+
+// Configure - configures specific variable
+func (o OptionFn) Configure(m *DefaultManager) error {
+	return o(m)
+}
+
+// Create - create new cluster, clusters created with a TTL expire instead
+// of disappearing once it passes. Clusters of accounts are subject to the
+// account quota.
+func (m *DefaultManager) Create(ctx context.Context, opts types.ClusterCreateOps) (*types.Cluster, error) {
+	if opts.Size == 0 {
+		opts.Size = 3
+	}
+
+	// clusters of an account are counted against its quota
+	if opts.AccountID != "" {
+		unlock := m.locks.lock(accountLockPrefix + opts.AccountID)
+		defer unlock()
+	}
+
+	if err := m.createAllowed(ctx, &opts); err != nil {
+		return nil, err
+	}
+
+	stored, created, err := reservations(opts.Reservations, opts.Size, m.allowLoopback)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	cluster := types.Cluster{
 		ID:        uuid.Generate(),
 		AccountID: opts.AccountID,
 		Name:      opts.Name,
 		Size:      opts.Size,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Phase:     types.ClusterPhaseForming,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+
+		Reservations: stored,
 	}
 
-	if cluster.Size == 0 {
-		cluster.Size = 3
+	if opts.TTL > 0 {
+		expiresAt := now.Add(time.Duration(opts.TTL) * time.Second)
+		cluster.ExpiresAt = &expiresAt
 	}
 
-	bts, err := m.serializer.Encode(&cluster)
+	bts, err := m.encode(ctx, &cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = m.store.Create(cluster.ID, bts, opts.TTL)
+	_, err = m.store.Create(ctx, cluster.ID, bts, 0)
 	if err != nil {
 		return nil, err
 	}
 
+	// tokens are only handed out once
+	cluster.Reservations = created
 	return &cluster, nil
 }
 
-// Get - get cluster by ID
-func (m *DefaultManager) Get(ref string) (*types.Cluster, error) {
-	kvp, err := m.store.Get(ref)
+// quota - quota of the account, nil when quotas aren't enforced
+func (m *DefaultManager) quota(ctx context.Context, accountID string) (*types.Quota, error) {
+	if accountID == "" || m.quotas == nil {
+		return nil, nil
+	}
+
+	q, err := m.quotas.Quota(ctx, accountID)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccount, accountID)
+	}
+	return q, err
+}
+
+// createAllowed - checks new cluster against the account quota, clusters of
+// accounts with a TTL limit get the maximum TTL unless they ask for less
+func (m *DefaultManager) createAllowed(ctx context.Context, opts *types.ClusterCreateOps) error {
+	q, err := m.quota(ctx, opts.AccountID)
+	if err != nil || q == nil {
+		return err
+	}
+
+	if err := sizeAllowed(q, opts.Size); err != nil {
+		return err
+	}
+
+	if q.MaxTTL > 0 && opts.TTL == 0 {
+		opts.TTL = q.MaxTTL
+	}
+	if err := ttlAllowed(q, opts.TTL); err != nil {
+		return err
+	}
+
+	if q.MaxClusters > 0 {
+		clusters, err := m.ListAccount(ctx, opts.AccountID)
+		if err != nil {
+			return err
+		}
+		if len(clusters) >= q.MaxClusters {
+			return fmt.Errorf("%w: at most %d clusters allowed", ErrQuotaExceeded, q.MaxClusters)
+		}
+	}
+	return nil
+}
+
+func sizeAllowed(q *types.Quota, size int) error {
+	if q.MaxSize > 0 && size > q.MaxSize {
+		return fmt.Errorf("%w: size %d above the limit of %d", ErrQuotaExceeded, size, q.MaxSize)
+	}
+	return nil
+}
+
+func ttlAllowed(q *types.Quota, ttl int64) error {
+	if q.MaxTTL > 0 && (ttl == 0 || ttl > q.MaxTTL) {
+		return fmt.Errorf("%w: TTL has to be between 1 and %d seconds", ErrQuotaExceeded, q.MaxTTL)
+	}
+	return nil
+}
+
+// Get - get cluster by ID, deleted clusters are not found
+func (m *DefaultManager) Get(ctx context.Context, ref string) (*types.Cluster, error) {
+	cluster, err := m.get(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.DeletedAt != nil {
+		return nil, store.ErrNotFound
+	}
+	return cluster, nil
+}
+
+// GetDeleted - get deleted cluster by ID, ErrClusterNotDeleted is returned
+// for clusters that aren't deleted
+func (m *DefaultManager) GetDeleted(ctx context.Context, id string) (*types.Cluster, error) {
+	cluster, err := m.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.DeletedAt == nil {
+		return nil, ErrClusterNotDeleted
+	}
+	return cluster, nil
+}
+
+// get - get cluster by ID including deleted clusters
+func (m *DefaultManager) get(ctx context.Context, ref string) (*types.Cluster, error) {
+	kvp, err := m.store.Get(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
+	return m.decodeCluster(ctx, kvp.Value)
+}
+
+// decodeCluster - decodes stored cluster, filling in the phase of clusters
+// stored before phases were tracked
+func (m *DefaultManager) decodeCluster(ctx context.Context, data []byte) (*types.Cluster, error) {
 	var cluster types.Cluster
-	err = m.serializer.Decode(kvp.Value, &cluster)
-	return &cluster, err
+	if err := m.decode(ctx, data, &cluster); err != nil {
+		return nil, err
+	}
+	if cluster.Phase == "" {
+		m.updatePhase(&cluster, time.Now())
+	}
+	return &cluster, nil
+}
+
+// clone - deep copy of the cluster, e.g. to return its state before a
+// change
+func (m *DefaultManager) clone(ctx context.Context, cluster *types.Cluster) (*types.Cluster, error) {
+	bts, err := m.encode(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	var c types.Cluster
+	if err := m.decode(ctx, bts, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// put - writes cluster, incrementing its version
+func (m *DefaultManager) put(ctx context.Context, cluster *types.Cluster) error {
+	cluster.Version++
+
+	bts, err := m.encode(ctx, cluster)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.store.Put(ctx, cluster.ID, bts, 0)
+	return err
+}
+
+// List - list all clusters, deleted clusters are not included
+func (m *DefaultManager) List(ctx context.Context) ([]*types.Cluster, error) {
+	all, err := m.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := all[:0]
+	for _, cluster := range all {
+		if cluster.DeletedAt == nil {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+// ListAccount - list clusters of the account, deleted clusters are not
+// included
+func (m *DefaultManager) ListAccount(ctx context.Context, accountID string) ([]*types.Cluster, error) {
+	all, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := all[:0]
+	for _, cluster := range all {
+		if cluster.AccountID == accountID {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters, nil
+}
+
+func (m *DefaultManager) list(ctx context.Context) ([]*types.Cluster, error) {
+	kvps, err := m.store.Enumerate(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// one span for all of them, a span per cluster would flood the trace
+	_, span := tracing.Start(ctx, "codec.Decode",
+		tracing.String("codec", m.serializer.Type()),
+		tracing.Int("codec.count", len(kvps)),
+	)
+	defer span.End()
+
+	now := time.Now()
+	clusters := make([]*types.Cluster, 0, len(kvps))
+	for _, kvp := range kvps {
+		var cluster types.Cluster
+		if err := m.serializer.Decode(kvp.Value, &cluster); err != nil {
+			err = fmt.Errorf("failed to decode cluster %s: %s", kvp.Key, err)
+			span.RecordError(err)
+			return nil, err
+		}
+		// like decodeCluster, clusters stored before phases were tracked
+		// get their phase filled in
+		if cluster.Phase == "" {
+			m.updatePhase(&cluster, now)
+		}
+		clusters = append(clusters, &cluster)
+	}
+	return clusters, nil
+}
+
+// encode - encodes v with the manager's serializer, recording a span
+func (m *DefaultManager) encode(ctx context.Context, v interface{}) ([]byte, error) {
+	_, span := tracing.Start(ctx, "codec.Encode", tracing.String("codec", m.serializer.Type()))
+	defer span.End()
+
+	bts, err := m.serializer.Encode(v)
+	span.RecordError(err)
+	span.SetAttributes(tracing.Int("codec.bytes", len(bts)))
+	return bts, err
+}
+
+// decode - decodes data with the manager's serializer, recording a span
+func (m *DefaultManager) decode(ctx context.Context, data []byte, v interface{}) error {
+	_, span := tracing.Start(ctx, "codec.Decode",
+		tracing.String("codec", m.serializer.Type()),
+		tracing.Int("codec.bytes", len(data)),
+	)
+	defer span.End()
+
+	err := m.serializer.Decode(data, v)
+	span.RecordError(err)
+	return err
 }
 
-func nodeValid(node *types.Node) error {
+func nodeValid(node *types.Node, allowLoopback bool) error {
 	if node.AdvertiseAddress == "" {
 		return ErrAddressMissing
 	}
@@ -100,78 +530,717 @@ func nodeValid(node *types.Node) error {
 		return ErrNameMissing
 	}
 
-	_, err := url.Parse(node.AdvertiseAddress)
+	_, err := NormalizeAddress(node.AdvertiseAddress, allowLoopback)
+	if err != nil {
+		return err
+	}
+
+	err = endpointsValid(node.Endpoints, allowLoopback)
 	if err != nil {
-		return ErrInvalidAddress
+		return err
+	}
+	return metadataValid(node)
+}
+
+// conflict - checks whether node clashes with an already registered node,
+// endpoints clash when both name and address match
+func conflict(registered, node *types.Node) error {
+	if registered.Name == node.Name {
+		return ErrNodeNamePresent
+	}
+
+	if sameAddress(registered.AdvertiseAddress, node.AdvertiseAddress) {
+		return ErrNodeAddressPresent
+	}
+
+	for _, e := range node.Endpoints {
+		for _, re := range registered.Endpoints {
+			if e.Name == re.Name && sameAddress(e.URL, re.URL) {
+				return fmt.Errorf("%w: %s endpoint %s", ErrNodeEndpointPresent, e.Name, e.URL)
+			}
+		}
 	}
 	return nil
 }
 
 // RegisterNode - register new node to the cluster
-func (m *DefaultManager) RegisterNode(clusterID string, node *types.Node) (updated *types.Cluster, err error) {
+func (m *DefaultManager) RegisterNode(ctx context.Context, clusterID string, node *types.Node) (before, updated *types.Cluster, err error) {
+
+	defaultAdvertiseAddress(node)
 
-	err = nodeValid(node)
+	err = nodeValid(node, m.allowLoopback)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	cluster, err := m.Get(clusterID)
+	// the lock keeps out changes that read and write the cluster separately,
+	// the registration itself is a single store transaction
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	var event *Event
+	_, err = m.store.Update(ctx, clusterID, func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, store.ErrNotFound
+		}
+		if before, err = m.decodeCluster(ctx, old); err != nil {
+			return nil, err
+		}
+		cluster, err := m.decodeCluster(ctx, old)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.DeletedAt != nil {
+			return nil, store.ErrNotFound
+		}
+
+		updated = cluster
+		now := time.Now()
+		if expired(cluster, now) {
+			return nil, ErrClusterExpired
+		}
+		registered, err := register(cluster, node, now)
+		if err != nil || !registered {
+			return nil, err
+		}
+
+		event = m.updatePhase(cluster, now)
+		cluster.Version++
+		return m.encode(ctx, cluster)
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	m.publish(event)
+
+	return before, updated, nil
+}
+
+// RegisterNodes - registers nodes to the cluster in a single write. Nodes
+// are registered in order, so they have to be unique among themselves as
+// well. Results describe the outcome for every node, when any of them is
+// rejected nothing is written and ErrBatchRejected is returned, wrapping
+// the error of the first rejected node.
+func (m *DefaultManager) RegisterNodes(ctx context.Context, clusterID string, nodes []*types.Node) (before, updated *types.Cluster, results []types.NodeResult, err error) {
+	if len(nodes) == 0 {
+		return nil, nil, nil, ErrBatchEmpty
+	}
+
+	results = make([]types.NodeResult, len(nodes))
+	var rejected error
+	for i, node := range nodes {
+		defaultAdvertiseAddress(node)
+		results[i] = types.NodeResult{Index: i, ID: node.ID, Name: node.Name, Status: types.NodeResultAborted}
+		if err := nodeValid(node, m.allowLoopback); err != nil {
+			results[i].Status = types.NodeResultRejected
+			results[i].Error = err.Error()
+			if rejected == nil {
+				rejected = fmt.Errorf("%w: node %d: %w", ErrBatchRejected, i, err)
+			}
+		}
+	}
+	if rejected != nil {
+		return nil, nil, results, rejected
+	}
+
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	var event *Event
+	_, err = m.store.Update(ctx, clusterID, func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, store.ErrNotFound
+		}
+		if before, err = m.decodeCluster(ctx, old); err != nil {
+			return nil, err
+		}
+		cluster, err := m.decodeCluster(ctx, old)
+		if err != nil {
+			return nil, err
+		}
+		if cluster.DeletedAt != nil {
+			return nil, store.ErrNotFound
+		}
+
+		updated = cluster
+		now := time.Now()
+		if expired(cluster, now) {
+			return nil, ErrClusterExpired
+		}
+
+		changed := false
+		var rejected error
+		for i, node := range nodes {
+			results[i].Status, results[i].Error = types.NodeResultExists, ""
+
+			registered, err := register(cluster, node, now)
+			switch {
+			case err != nil:
+				results[i].Status, results[i].Error = types.NodeResultRejected, err.Error()
+				if rejected == nil {
+					rejected = fmt.Errorf("%w: node %d: %w", ErrBatchRejected, i, err)
+				}
+			case registered:
+				results[i].Status = types.NodeResultRegistered
+				changed = true
+			}
+		}
+		if rejected != nil {
+			for i := range results {
+				if results[i].Status == types.NodeResultRegistered {
+					results[i].Status = types.NodeResultAborted
+				}
+			}
+			return nil, rejected
+		}
+		if !changed {
+			return nil, nil
+		}
+
+		event = m.updatePhase(cluster, now)
+		cluster.Version++
+		return m.encode(ctx, cluster)
+	})
+	if errors.Is(err, ErrBatchRejected) {
+		return nil, nil, results, err
+	}
+	if err != nil {
+		return nil, nil, nil, err
 	}
+	m.publish(event)
+
+	return before, updated, results, nil
+}
 
+// register - adds node to the cluster, false when the node is already
+// registered
+func register(cluster *types.Cluster, node *types.Node, now time.Time) (bool, error) {
 	// looking for duplicates
 	for _, n := range cluster.Nodes {
-		if n.Name == node.Name && n.AdvertiseAddress == node.AdvertiseAddress && n.ID == node.ID {
+		if n.Name == node.Name && sameAddress(n.AdvertiseAddress, node.AdvertiseAddress) && n.ID == node.ID {
 			// node already registered, nothing to do
-			return cluster, nil
+			return false, nil
+		}
+
+		if err := conflict(n, node); err != nil {
+			return false, err
+		}
+	}
+
+	if err := claimSlot(cluster, node); err != nil {
+		return false, err
+	}
+
+	// the node is copied so that its slot token isn't stored, yet stays
+	// available in case the write is retried
+	registered := *node
+	registered.SlotToken = ""
+	registered.CreatedAt = now
+	registered.UpdatedAt = now
+	registered.LastSeen = now
+
+	cluster.Nodes = append(cluster.Nodes, &registered)
+	cluster.UpdatedAt = now
+
+	return true, nil
+}
+
+// UpdateNode - updates address, name or metadata of a node registered
+// under the given ID, e.g. when node got a new address after reboot.
+// Updated node has to stay unique within the cluster.
+func (m *DefaultManager) UpdateNode(ctx context.Context, clusterID, nodeID string, update *types.NodeUpdate) (before, updated *types.Cluster, err error) {
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+	cluster, err := m.Get(ctx, clusterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err = m.clone(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if expired(cluster, time.Now()) {
+		return nil, nil, ErrClusterExpired
+	}
+
+	idx := findNode(cluster, nodeID)
+	if idx < 0 {
+		return nil, nil, ErrNodeNotFound
+	}
+
+	node := *cluster.Nodes[idx]
+	applyNodeUpdate(&node, update)
+	defaultAdvertiseAddress(&node)
+
+	err = nodeValid(&node, m.allowLoopback)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, n := range cluster.Nodes {
+		if i == idx {
+			continue
+		}
+		if err := conflict(n, &node); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := slotKept(cluster, &node); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	node.UpdatedAt = now
+	cluster.Nodes[idx] = &node
+	cluster.UpdatedAt = now
+
+	err = m.put(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return before, cluster, nil
+}
+
+func applyNodeUpdate(node *types.Node, update *types.NodeUpdate) {
+	if update.Name != nil {
+		node.Name = *update.Name
+	}
+	if update.AdvertiseAddress != nil {
+		node.AdvertiseAddress = *update.AdvertiseAddress
+	}
+	if update.Endpoints != nil {
+		node.Endpoints = *update.Endpoints
+	}
+	if update.Labels != nil {
+		node.Labels = *update.Labels
+	}
+	if update.Role != nil {
+		node.Role = *update.Role
+	}
+	if update.Zone != nil {
+		node.Zone = *update.Zone
+	}
+	if update.Region != nil {
+		node.Region = *update.Region
+	}
+	if update.Version != nil {
+		node.Version = *update.Version
+	}
+}
+
+// Update - update cluster
+func (m *DefaultManager) Update(ctx context.Context, cluster *types.Cluster) error {
+	unlock := m.locks.lock(cluster.ID)
+	defer unlock()
+
+	return m.put(ctx, cluster)
+}
+
+// UpdateCluster - changes name, size or TTL of the cluster. The update is
+// rejected with ErrVersionMismatch unless version is 0 or matches the
+// stored one, size can't drop below the number of founding nodes.
+func (m *DefaultManager) UpdateCluster(ctx context.Context, id string, update *types.ClusterUpdate, version uint64) (before, updated *types.Cluster, err error) {
+	unlock := m.locks.lock(id)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err = m.clone(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != 0 && cluster.Version != version {
+		return nil, nil, ErrVersionMismatch
+	}
+
+	now := time.Now()
+	if expired(cluster, now) {
+		return nil, nil, ErrClusterExpired
+	}
+
+	// clusters outliving their account are no longer limited
+	q, err := m.quota(ctx, cluster.AccountID)
+	if err != nil && !errors.Is(err, ErrUnknownAccount) {
+		return nil, nil, err
+	}
+
+	if update.Name != nil {
+		cluster.Name = *update.Name
+	}
+
+	if update.Size != nil {
+		if *update.Size <= 0 {
+			return nil, nil, ErrInvalidSize
+		}
+		// nodes registered so far become founding members once the cluster
+		// completes
+		founding := len(cluster.Nodes)
+		if cluster.CompletedAt != nil {
+			founding = len(cluster.FoundingMembers)
+		}
+		if *update.Size < founding {
+			return nil, nil, fmt.Errorf("%w: %d founding nodes", ErrSizeTooSmall, founding)
+		}
+		if *update.Size < len(cluster.Reservations) {
+			return nil, nil, fmt.Errorf("%w: %d reserved slots", ErrSizeTooSmall, len(cluster.Reservations))
+		}
+		if q != nil {
+			if err := sizeAllowed(q, *update.Size); err != nil {
+				return nil, nil, err
+			}
+		}
+		cluster.Size = *update.Size
+	}
+
+	if update.TTL != nil {
+		switch {
+		case *update.TTL < 0:
+			return nil, nil, ErrInvalidTTL
+		case q != nil && ttlAllowed(q, *update.TTL) != nil:
+			return nil, nil, ttlAllowed(q, *update.TTL)
+		case *update.TTL == 0:
+			cluster.ExpiresAt = nil
+		default:
+			expiresAt := now.Add(time.Duration(*update.TTL) * time.Second)
+			cluster.ExpiresAt = &expiresAt
+		}
+	}
+
+	cluster.UpdatedAt = now
+
+	event := m.updatePhase(cluster, now)
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, nil, err
+	}
+	m.publish(event)
+
+	return before, cluster, nil
+}
+
+func clusterValid(cluster *types.Cluster, allowLoopback bool) error {
+	if cluster.ID == "" {
+		return ErrClusterIDMissing
+	}
+
+	if cluster.Size <= 0 {
+		return ErrInvalidSize
+	}
+	if len(cluster.Reservations) > cluster.Size {
+		return fmt.Errorf("%w: %d reservations for %d nodes", ErrInvalidReservation, len(cluster.Reservations), cluster.Size)
+	}
+
+	for i, node := range cluster.Nodes {
+		if node == nil {
+			return ErrNameMissing
 		}
+		if err := nodeValid(node, allowLoopback); err != nil {
+			return err
+		}
+		for _, n := range cluster.Nodes[:i] {
+			if err := conflict(n, node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Import - import clusters, e.g. exported from another environment. Every
+// cluster is validated and checked for conflicts before anything is
+// written so a rejected import leaves the store untouched.
+func (m *DefaultManager) Import(ctx context.Context, clusters []*types.Cluster, policy ImportPolicy) (*ImportReport, error) {
+	switch policy {
+	case ImportSkip, ImportOverwrite, ImportFail:
+	default:
+		return nil, ErrUnknownImportPolicy
+	}
 
-		if n.Name == node.Name {
-			return nil, ErrNodeNamePresent
+	seen := make(map[string]bool, len(clusters))
+	for i, cluster := range clusters {
+		if err := clusterValid(cluster, m.allowLoopback); err != nil {
+			return nil, &ImportError{Index: i, ClusterID: cluster.ID, Err: err}
+		}
+		if seen[cluster.ID] {
+			return nil, &ImportError{Index: i, ClusterID: cluster.ID, Err: ErrClusterExists}
 		}
+		seen[cluster.ID] = true
+	}
 
-		if n.AdvertiseAddress == node.AdvertiseAddress {
-			return nil, ErrNodeAddressPresent
+	unlock := m.locks.lockAll()
+	defer unlock()
+
+	// versions of clusters that exist, overwriting continues from them so
+	// that ETags never repeat
+	exists := make(map[string]uint64, len(clusters))
+	for i, cluster := range clusters {
+		current, err := m.get(ctx, cluster.ID)
+		switch err {
+		case nil:
+			if policy == ImportFail {
+				return nil, &ImportError{Index: i, ClusterID: cluster.ID, Err: ErrClusterExists}
+			}
+			exists[cluster.ID] = current.Version
+		case store.ErrNotFound:
+		default:
+			return nil, err
 		}
 	}
 
-	node.CreatedAt = time.Now()
-	node.UpdatedAt = time.Now()
+	// a partial import can't be undone, cancelling stops mattering once
+	// writing started
+	ctx = context.WithoutCancel(ctx)
+
+	report := &ImportReport{
+		Created:     []string{},
+		Overwritten: []string{},
+		Skipped:     []string{},
+	}
+	for _, cluster := range clusters {
+		version, overwrite := exists[cluster.ID]
+		if overwrite && policy == ImportSkip {
+			report.Skipped = append(report.Skipped, cluster.ID)
+			continue
+		}
+		if overwrite {
+			cluster.Version = version
+		}
+
+		if err := m.put(ctx, cluster); err != nil {
+			return report, err
+		}
 
-	cluster.Nodes = append(cluster.Nodes, node)
+		if overwrite {
+			report.Overwritten = append(report.Overwritten, cluster.ID)
+		} else {
+			report.Created = append(report.Created, cluster.ID)
+		}
+	}
 
-	bts, err := m.serializer.Encode(cluster)
+	return report, nil
+}
+
+// Delete - marks cluster as deleted, cluster is purged once the delete
+// grace period passes. ErrNotFound is returned for unknown or already
+// deleted clusters, the deleted cluster is returned otherwise.
+func (m *DefaultManager) Delete(ctx context.Context, id string) (*types.Cluster, error) {
+	unlock := m.locks.lock(id)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	before, err := m.clone(ctx, cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = m.store.Put(cluster.ID, bts, 0)
+	now := time.Now()
+	cluster.DeletedAt = &now
+
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, err
+	}
+	return before, nil
+}
+
+// Undelete - restores deleted cluster, ErrNotFound is returned if cluster
+// doesn't exist or its grace period passed. Clusters of accounts are
+// subject to the account quota as if they were created again.
+func (m *DefaultManager) Undelete(ctx context.Context, id string) (*types.Cluster, error) {
+	cluster, err := m.get(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
+	// account of a cluster never changes, restoring is serialized with
+	// creating clusters of the account
+	keys := []string{id}
+	if cluster.AccountID != "" {
+		keys = append(keys, accountLockPrefix+cluster.AccountID)
+	}
+	unlock := m.locks.lockKeys(keys...)
+	defer unlock()
+
+	cluster, err = m.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cluster.DeletedAt == nil {
+		return nil, ErrClusterNotDeleted
+	}
+	// the reaper may not have purged it yet
+	now := time.Now()
+	if now.Sub(*cluster.DeletedAt) >= m.deleteGracePeriod {
+		return nil, store.ErrNotFound
+	}
+	if err := m.restoreAllowed(ctx, cluster, now); err != nil {
+		return nil, err
+	}
+
+	cluster.DeletedAt = nil
+	cluster.UpdatedAt = now
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, err
+	}
 	return cluster, nil
 }
 
-// Update - update cluster
-func (m *DefaultManager) Update(cluster *types.Cluster) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// restoreAllowed - checks deleted cluster against the account quota,
+// expired clusters can't be restored and clusters without TTL get the
+// maximum TTL of the account
+func (m *DefaultManager) restoreAllowed(ctx context.Context, cluster *types.Cluster, now time.Time) error {
+	if expired(cluster, now) {
+		return ErrClusterExpired
+	}
 
-	bts, err := m.serializer.Encode(cluster)
-	if err != nil {
+	opts := types.ClusterCreateOps{AccountID: cluster.AccountID, Size: cluster.Size}
+	if cluster.ExpiresAt != nil {
+		opts.TTL = int64(math.Ceil(cluster.ExpiresAt.Sub(now).Seconds()))
+	}
+	if err := m.createAllowed(ctx, &opts); err != nil {
 		return err
 	}
 
-	_, err = m.store.Put(cluster.ID, bts, 0)
+	if cluster.ExpiresAt == nil && opts.TTL > 0 {
+		expiresAt := now.Add(time.Duration(opts.TTL) * time.Second)
+		cluster.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// Purge - delete cluster by ID immediately
+func (m *DefaultManager) Purge(ctx context.Context, id string) error {
+	return m.store.Delete(ctx, id)
+}
+
+// Reap - purges deleted and expired clusters whose grace period passed,
+// number of purged clusters is returned
+func (m *DefaultManager) Reap(now time.Time) (int, error) {
+	ctx := context.Background()
 
-	return err
+	clusters, err := m.list(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, cluster := range clusters {
+		if !m.reapable(cluster, now) {
+			continue
+		}
+		ok, err := m.reap(ctx, cluster.ID, now)
+		if err != nil {
+			return purged, err
+		}
+		if ok {
+			atomic.AddUint64(&m.reaper.reaped, 1)
+			purged++
+		}
+	}
+	return purged, nil
 }
 
-// Delete - delete cluster by ID
-func (m *DefaultManager) Delete(id string) error {
-	return m.store.Delete(id)
+// reap - purges the cluster unless it was restored or changed since it was
+// listed
+func (m *DefaultManager) reap(ctx context.Context, id string, now time.Time) (bool, error) {
+	unlock := m.locks.lock(id)
+	defer unlock()
+
+	cluster, err := m.get(ctx, id)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	if err != nil || !m.reapable(cluster, now) {
+		return false, err
+	}
+	return true, m.Purge(ctx, id)
+}
+
+// reapable - deleted and expired clusters are purged once the delete grace
+// period passes
+func (m *DefaultManager) reapable(cluster *types.Cluster, now time.Time) bool {
+	switch {
+	case cluster.DeletedAt != nil:
+		return now.Sub(*cluster.DeletedAt) >= m.deleteGracePeriod
+	case cluster.ExpiresAt != nil:
+		return now.Sub(*cluster.ExpiresAt) >= m.deleteGracePeriod
+	}
+	return false
+}
+
+// StartReaper - updates phases of clusters and purges expired and deleted
+// clusters in the background
+func (m *DefaultManager) StartReaper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				atomic.AddUint64(&m.reaper.runs, 1)
+
+				if _, err := m.Refresh(time.Now()); err != nil {
+					slog.Error("failed to refresh cluster phases", logging.FieldError, err)
+				}
+
+				n, err := m.Reap(time.Now())
+				if err != nil {
+					slog.Error("failed to purge deleted clusters", logging.FieldError, err)
+					continue
+				}
+				if n > 0 {
+					slog.Info("purged deleted or expired clusters", "count", n)
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop - stops background reaper
+func (m *DefaultManager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// Snapshot - writes consistent snapshot of the underlying store to w
+func (m *DefaultManager) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	snapshotter, ok := m.store.(store.Snapshotter)
+	if !ok {
+		return 0, store.ErrNotSupported
+	}
+	return snapshotter.Snapshot(ctx, w)
+}
+
+// Restore - replaces the underlying store with the snapshot read from r,
+// snapshot is rejected unless every record in it decodes into a cluster
+func (m *DefaultManager) Restore(ctx context.Context, r io.Reader) error {
+	snapshotter, ok := m.store.(store.Snapshotter)
+	if !ok {
+		return store.ErrNotSupported
+	}
+
+	unlock := m.locks.lockAll()
+	defer unlock()
+
+	return snapshotter.Restore(ctx, r, m.validateRecord)
+}
+
+func (m *DefaultManager) validateRecord(kvp *store.KVPair) error {
+	var cluster types.Cluster
+	if err := m.serializer.Decode(kvp.Value, &cluster); err != nil {
+		return err
+	}
+	if cluster.ID != kvp.Key {
+		return fmt.Errorf("cluster ID %s doesn't match key", cluster.ID)
+	}
+	return nil
 }
@@ -0,0 +1,221 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/storageos/discovery/types"
+)
+
+// leader errors
+var (
+	ErrNoLeader          = errors.New("cluster has no bootstrap leader yet")
+	ErrNotLeader         = errors.New("node is not the bootstrap leader")
+	ErrStaleEpoch        = errors.New("stale leader epoch")
+	ErrLeaderConfirmed   = errors.New("bootstrap leader already confirmed")
+	ErrHandoverTooEarly  = errors.New("bootstrap leader confirmation timeout hasn't passed yet")
+	ErrNoLeaderCandidate = errors.New("no other node can take over leadership")
+	ErrUnknownLeaderRule = errors.New("unknown leader rule")
+)
+
+// LeaderRule - how the bootstrap leader is chosen
+type LeaderRule string
+
+// leader rules
+const (
+	// LeaderRuleFirst picks nodes in registration order
+	LeaderRuleFirst LeaderRule = "first"
+	// LeaderRuleLowestID picks nodes in lexicographic order of their IDs
+	LeaderRuleLowestID LeaderRule = "lowest-id"
+)
+
+// DefaultLeaderTimeout - default time the bootstrap leader has to confirm
+// initialization before leadership can be handed over
+const DefaultLeaderTimeout = 5 * time.Minute
+
+// ParseLeaderRule - parses leader rule, empty string is the default rule
+func ParseLeaderRule(rule string) (LeaderRule, error) {
+	switch LeaderRule(rule) {
+	case "", LeaderRuleFirst:
+		return LeaderRuleFirst, nil
+	case LeaderRuleLowestID:
+		return LeaderRuleLowestID, nil
+	}
+	return "", fmt.Errorf("%w: %q, expected %s or %s", ErrUnknownLeaderRule, rule, LeaderRuleFirst, LeaderRuleLowestID)
+}
+
+// WithLeaderRule - how the bootstrap leader is chosen
+func WithLeaderRule(rule LeaderRule) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.leaderRule = rule
+		return nil
+	})
+}
+
+// WithLeaderTimeout - how long the bootstrap leader has to confirm
+// initialization before other nodes can take over
+func WithLeaderTimeout(d time.Duration) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.leaderTimeout = d
+		return nil
+	})
+}
+
+// candidates - node IDs in the order they are considered for leadership
+func (m *DefaultManager) candidates(ids []string) []string {
+	ordered := append([]string(nil), ids...)
+	if m.leaderRule == LeaderRuleLowestID {
+		sort.Strings(ordered)
+	}
+	return ordered
+}
+
+// elect - designates bootstrap leader among founding members once cluster
+// completed
+func (m *DefaultManager) elect(cluster *types.Cluster, now time.Time) {
+	if cluster.Leader != nil || cluster.CompletedAt == nil || len(cluster.FoundingMembers) == 0 {
+		return
+	}
+
+	cluster.Leader = &types.Leader{
+		NodeID:    m.candidates(cluster.FoundingMembers)[0],
+		Epoch:     1,
+		ElectedAt: now,
+	}
+}
+
+// handover - passes leadership to the next candidate after the current
+// leader, every handover increments the epoch so a deposed leader can be
+// fenced off
+func (m *DefaultManager) handover(cluster *types.Cluster, now time.Time) error {
+	ids := make([]string, 0, len(cluster.Nodes))
+	for _, n := range cluster.Nodes {
+		ids = append(ids, nodeID(n))
+	}
+	ordered := m.candidates(ids)
+
+	// continue right after the current leader, which might have left the
+	// cluster already
+	current := cluster.Leader.NodeID
+	start := 0
+	for i, id := range ordered {
+		if id == current {
+			start = i + 1
+			break
+		}
+		if m.leaderRule == LeaderRuleLowestID && id > current {
+			start = i
+			break
+		}
+	}
+
+	next := ""
+	for k := range ordered {
+		if id := ordered[(start+k)%len(ordered)]; id != current {
+			next = id
+			break
+		}
+	}
+	if next == "" {
+		return ErrNoLeaderCandidate
+	}
+
+	cluster.Leader = &types.Leader{
+		NodeID:    next,
+		Epoch:     cluster.Leader.Epoch + 1,
+		ElectedAt: now,
+	}
+	return nil
+}
+
+// ConfirmLeader - bootstrap leader confirms it initialized the cluster,
+// epoch has to match the current one so a deposed leader can't confirm
+func (m *DefaultManager) ConfirmLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error) {
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, clusterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err = m.clone(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if expired(cluster, now) {
+		return nil, nil, ErrClusterExpired
+	}
+	if cluster.Leader == nil {
+		return nil, nil, ErrNoLeader
+	}
+	if cluster.Leader.NodeID != nodeID {
+		return nil, nil, ErrNotLeader
+	}
+	if cluster.Leader.Epoch != epoch {
+		return nil, nil, ErrStaleEpoch
+	}
+	if cluster.Leader.ConfirmedAt != nil {
+		return before, cluster, nil
+	}
+
+	cluster.Leader.ConfirmedAt = &now
+	cluster.UpdatedAt = now
+
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, nil, err
+	}
+	return before, cluster, nil
+}
+
+// HandoverLeader - passes bootstrap leadership to the next node. The leader
+// can hand over at any time before it confirmed, other members only once
+// the leader timeout passed without confirmation. Epoch has to match the
+// current one so concurrent handovers only happen once.
+func (m *DefaultManager) HandoverLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error) {
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, clusterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err = m.clone(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if expired(cluster, now) {
+		return nil, nil, ErrClusterExpired
+	}
+	if findNode(cluster, nodeID) < 0 {
+		return nil, nil, ErrNodeNotFound
+	}
+	if cluster.Leader == nil {
+		return nil, nil, ErrNoLeader
+	}
+	if cluster.Leader.Epoch != epoch {
+		return nil, nil, ErrStaleEpoch
+	}
+	if cluster.Leader.ConfirmedAt != nil {
+		return nil, nil, ErrLeaderConfirmed
+	}
+	if cluster.Leader.NodeID != nodeID && now.Sub(cluster.Leader.ElectedAt) < m.leaderTimeout {
+		return nil, nil, ErrHandoverTooEarly
+	}
+
+	if err := m.handover(cluster, now); err != nil {
+		return nil, nil, err
+	}
+	cluster.UpdatedAt = now
+
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, nil, err
+	}
+	return before, cluster, nil
+}
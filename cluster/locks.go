@@ -0,0 +1,68 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// lockStripes - number of cluster locks, clusters hashing to the same
+// stripe share a lock
+const lockStripes = 256
+
+// clusterLocks - striped per-cluster locks. Changes of a single cluster are
+// serialized while different clusters are changed concurrently, without
+// keeping a lock for every cluster ever seen.
+type clusterLocks [lockStripes]sync.Mutex
+
+func stripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % lockStripes)
+}
+
+// lock - locks the stripe of the key, the returned function unlocks it
+func (l *clusterLocks) lock(key string) func() {
+	mu := &l[stripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockKeys - locks the stripes of several keys, e.g. of a cluster and its
+// account. Keys sharing a stripe lock it once and stripes are locked in
+// ascending order so concurrent callers can't deadlock.
+func (l *clusterLocks) lockKeys(keys ...string) func() {
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, stripe(key))
+	}
+	sort.Ints(stripes)
+
+	locked := stripes[:0]
+	for i, s := range stripes {
+		if i > 0 && s == stripes[i-1] {
+			continue
+		}
+		l[s].Lock()
+		locked = append(locked, s)
+	}
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			l[locked[i]].Unlock()
+		}
+	}
+}
+
+// lockAll - locks every stripe, e.g. while many clusters are written at
+// once. Stripes are always locked in the same order so concurrent callers
+// can't deadlock.
+func (l *clusterLocks) lockAll() func() {
+	for i := range l {
+		l[i].Lock()
+	}
+	return func() {
+		for i := len(l) - 1; i >= 0; i-- {
+			l[i].Unlock()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/storageos/discovery/types"
+)
+
+// node metadata errors
+var (
+	ErrInvalidRole     = errors.New("invalid node role")
+	ErrInvalidLabels   = errors.New("invalid node labels")
+	ErrInvalidMetadata = errors.New("invalid node metadata")
+	ErrInvalidEndpoint = errors.New("invalid node endpoint")
+)
+
+// node metadata limits
+const (
+	MaxLabels           = 64
+	MaxLabelKeyLength   = 63
+	MaxLabelValueLength = 255
+	MaxLocationLength   = 63
+	MaxVersionLength    = 64
+	MaxNodeNameLength   = 253
+	MaxEndpoints        = 16
+)
+
+// IsValidationError - whether the error is caused by an invalid node
+func IsValidationError(err error) bool {
+	for _, target := range []error{ErrAddressMissing, ErrInvalidAddress, ErrNameMissing, ErrIDMissing, ErrInvalidRole, ErrInvalidLabels, ErrInvalidMetadata, ErrInvalidEndpoint} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+var labelKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]*[a-zA-Z0-9])?$`)
+
+// defaultAdvertiseAddress - nodes registering only endpoints use the first
+// one as their primary address
+func defaultAdvertiseAddress(node *types.Node) {
+	if node.AdvertiseAddress == "" && len(node.Endpoints) > 0 {
+		node.AdvertiseAddress = node.Endpoints[0].URL
+	}
+}
+
+func endpointsValid(endpoints []types.Endpoint, allowLoopback bool) error {
+	if len(endpoints) > MaxEndpoints {
+		return fmt.Errorf("%w: %d endpoints, at most %d allowed", ErrInvalidEndpoint, len(endpoints), MaxEndpoints)
+	}
+
+	names := make(map[string]bool, len(endpoints))
+	for _, e := range endpoints {
+		if len(e.Name) > MaxLabelKeyLength || !labelKeyRegexp.MatchString(e.Name) {
+			return fmt.Errorf("%w: name %q must be at most %d alphanumeric, '.', '_', '/' or '-' characters", ErrInvalidEndpoint, e.Name, MaxLabelKeyLength)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("%w: duplicate name %q", ErrInvalidEndpoint, e.Name)
+		}
+		names[e.Name] = true
+
+		if _, err := NormalizeAddress(e.URL, allowLoopback); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrInvalidEndpoint, e.Name, err)
+		}
+	}
+	return nil
+}
+
+func metadataValid(node *types.Node) error {
+	switch node.Role {
+	case "", types.NodeRoleController, types.NodeRoleStorage, types.NodeRoleWitness:
+	default:
+		return fmt.Errorf("%w: %q, expected one of %s, %s, %s", ErrInvalidRole, node.Role,
+			types.NodeRoleController, types.NodeRoleStorage, types.NodeRoleWitness)
+	}
+
+	if len(node.Labels) > MaxLabels {
+		return fmt.Errorf("%w: %d labels, at most %d allowed", ErrInvalidLabels, len(node.Labels), MaxLabels)
+	}
+	for k, v := range node.Labels {
+		if len(k) > MaxLabelKeyLength || !labelKeyRegexp.MatchString(k) {
+			return fmt.Errorf("%w: key %q must be at most %d alphanumeric, '.', '_', '/' or '-' characters", ErrInvalidLabels, k, MaxLabelKeyLength)
+		}
+		if len(v) > MaxLabelValueLength {
+			return fmt.Errorf("%w: value of %q longer than %d characters", ErrInvalidLabels, k, MaxLabelValueLength)
+		}
+	}
+
+	if len(node.Name) > MaxNodeNameLength {
+		return fmt.Errorf("%w: name longer than %d characters", ErrInvalidMetadata, MaxNodeNameLength)
+	}
+	if len(node.Zone) > MaxLocationLength {
+		return fmt.Errorf("%w: zone longer than %d characters", ErrInvalidMetadata, MaxLocationLength)
+	}
+	if len(node.Region) > MaxLocationLength {
+		return fmt.Errorf("%w: region longer than %d characters", ErrInvalidMetadata, MaxLocationLength)
+	}
+	if len(node.Version) > MaxVersionLength {
+		return fmt.Errorf("%w: version longer than %d characters", ErrInvalidMetadata, MaxVersionLength)
+	}
+
+	return nil
+}
+
+// NodeFilter - selects nodes by role and labels, zero value matches all
+// nodes
+type NodeFilter struct {
+	Role string
+	// Labels nodes must carry, an empty value only requires the label to
+	// be present. "zone" and "region" select on the node's zone and region.
+	Labels map[string]string
+}
+
+// ParseLabelSelector - parses "key=value" or "key" label selectors into the
+// filter
+func (f *NodeFilter) ParseLabelSelector(selector string) error {
+	key, value := selector, ""
+	if i := strings.Index(selector, "="); i >= 0 {
+		key, value = selector[:i], selector[i+1:]
+	}
+	if key == "" {
+		return fmt.Errorf("%w: invalid label selector %q", ErrInvalidLabels, selector)
+	}
+	if f.Labels == nil {
+		f.Labels = map[string]string{}
+	}
+	f.Labels[key] = value
+	return nil
+}
+
+// Match - whether the node matches the filter
+func (f *NodeFilter) Match(node *types.Node) bool {
+	if f.Role != "" && node.Role != f.Role {
+		return false
+	}
+	for k, v := range f.Labels {
+		actual, ok := nodeLabel(node, k)
+		if !ok || (v != "" && actual != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeLabel - value of the label on the node, zone and region fields take
+// precedence over labels of the same name
+func nodeLabel(node *types.Node, key string) (string, bool) {
+	switch {
+	case key == "zone" && node.Zone != "":
+		return node.Zone, true
+	case key == "region" && node.Region != "":
+		return node.Region, true
+	}
+	value, ok := node.Labels[key]
+	return value, ok
+}
+
+// FilterNodes - nodes matching the filter, order is preserved
+func FilterNodes(nodes []*types.Node, f NodeFilter) []*types.Node {
+	filtered := []*types.Node{}
+	for _, n := range nodes {
+		if f.Match(n) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
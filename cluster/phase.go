@@ -0,0 +1,275 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+)
+
+// ErrClusterExpired - cluster TTL passed, expired clusters can't be changed
+var ErrClusterExpired = errors.New("cluster expired")
+
+// Event - cluster phase transition
+type Event struct {
+	ClusterID string             `json:"clusterID"`
+	From      types.ClusterPhase `json:"from"`
+	To        types.ClusterPhase `json:"to"`
+	Timestamp time.Time          `json:"timestamp"`
+}
+
+// EventHandler - receives phase transitions. Handlers are called
+// synchronously while the cluster is locked so they must not call back into
+// the manager.
+type EventHandler func(Event)
+
+// WithHeartbeatTimeout - marks completed clusters as degraded once any of
+// the members didn't heartbeat for longer than the timeout, 0 disables
+// the check
+func WithHeartbeatTimeout(d time.Duration) Option {
+	return OptionFn(func(m *DefaultManager) error {
+		m.heartbeatTimeout = d
+		return nil
+	})
+}
+
+// Subscribe - registers handler for phase transitions
+func (m *DefaultManager) Subscribe(h EventHandler) {
+	m.handlersMu.Lock()
+	defer m.handlersMu.Unlock()
+
+	m.handlers = append(m.handlers, h)
+}
+
+func (m *DefaultManager) publish(e *Event) {
+	if e == nil {
+		return
+	}
+
+	m.handlersMu.RLock()
+	defer m.handlersMu.RUnlock()
+
+	for _, h := range m.handlers {
+		h(*e)
+	}
+}
+
+// updatePhase - moves cluster into its current phase, the transition is
+// returned if the phase changed. Clusters stored before phases were
+// introduced have no phase, moving them into one is not a transition.
+func (m *DefaultManager) updatePhase(cluster *types.Cluster, now time.Time) *Event {
+	from := cluster.Phase
+	cluster.Phase = m.phase(cluster, now)
+	if cluster.Phase != types.ClusterPhaseExpired {
+		m.elect(cluster, now)
+	}
+
+	if from == "" || from == cluster.Phase {
+		return nil
+	}
+	return &Event{ClusterID: cluster.ID, From: from, To: cluster.Phase, Timestamp: now}
+}
+
+func (m *DefaultManager) phase(cluster *types.Cluster, now time.Time) types.ClusterPhase {
+	if expired(cluster, now) {
+		return types.ClusterPhaseExpired
+	}
+
+	if cluster.CompletedAt == nil {
+		if len(cluster.Nodes) < cluster.Size {
+			return types.ClusterPhaseForming
+		}
+
+		completed := now
+		cluster.CompletedAt = &completed
+		cluster.FoundingMembers = make([]string, 0, cluster.Size)
+		for _, n := range cluster.Nodes[:cluster.Size] {
+			cluster.FoundingMembers = append(cluster.FoundingMembers, nodeID(n))
+		}
+		return types.ClusterPhaseComplete
+	}
+
+	if len(cluster.Nodes) < cluster.Size {
+		return types.ClusterPhaseDegraded
+	}
+
+	for _, n := range cluster.Nodes {
+		if m.heartbeatTimeout > 0 && now.Sub(n.LastSeen) > m.heartbeatTimeout {
+			return types.ClusterPhaseDegraded
+		}
+	}
+
+	// cluster is active once every member reported in after it completed
+	for _, n := range cluster.Nodes {
+		if !n.LastSeen.After(*cluster.CompletedAt) {
+			if cluster.Phase == types.ClusterPhaseDegraded {
+				return types.ClusterPhaseDegraded
+			}
+			return types.ClusterPhaseComplete
+		}
+	}
+	return types.ClusterPhaseActive
+}
+
+// expired - expired clusters stay expired, e.g. when the clock goes back
+func expired(cluster *types.Cluster, now time.Time) bool {
+	if cluster.Phase == types.ClusterPhaseExpired {
+		return true
+	}
+	return cluster.ExpiresAt != nil && !now.Before(*cluster.ExpiresAt)
+}
+
+// nodeID - nodes are identified by ID, falling back to name for nodes
+// registered without one
+func nodeID(n *types.Node) string {
+	if n.ID != "" {
+		return n.ID
+	}
+	return n.Name
+}
+
+// Heartbeat - marks node as alive
+func (m *DefaultManager) Heartbeat(ctx context.Context, clusterID, nodeID string) (*types.Cluster, error) {
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, clusterID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if expired(cluster, now) {
+		return nil, ErrClusterExpired
+	}
+
+	idx := findNode(cluster, nodeID)
+	if idx < 0 {
+		return nil, ErrNodeNotFound
+	}
+	cluster.Nodes[idx].LastSeen = now
+	cluster.UpdatedAt = now
+
+	event := m.updatePhase(cluster, now)
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, err
+	}
+	m.publish(event)
+
+	return cluster, nil
+}
+
+// DeregisterNode - removes node from the cluster, e.g. when it leaves for
+// good. Founding members are kept so a completed cluster that lost a
+// member becomes degraded. Leadership of an unconfirmed leader is handed
+// over to the next node.
+func (m *DefaultManager) DeregisterNode(ctx context.Context, clusterID, nodeID string) (before, updated *types.Cluster, err error) {
+	unlock := m.locks.lock(clusterID)
+	defer unlock()
+
+	cluster, err := m.Get(ctx, clusterID)
+	if err != nil {
+		return nil, nil, err
+	}
+	before, err = m.clone(ctx, cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if expired(cluster, now) {
+		return nil, nil, ErrClusterExpired
+	}
+
+	idx := findNode(cluster, nodeID)
+	if idx < 0 {
+		return nil, nil, ErrNodeNotFound
+	}
+
+	// handing over while the leader is still a member keeps its position
+	// in the candidate order
+	if l := cluster.Leader; l != nil && l.ConfirmedAt == nil && l.NodeID == nodeID {
+		if err := m.handover(cluster, now); err != nil && err != ErrNoLeaderCandidate {
+			return nil, nil, err
+		}
+	}
+
+	cluster.Nodes = append(cluster.Nodes[:idx], cluster.Nodes[idx+1:]...)
+	releaseSlot(cluster, nodeID)
+	cluster.UpdatedAt = now
+
+	event := m.updatePhase(cluster, now)
+	if err := m.put(ctx, cluster); err != nil {
+		return nil, nil, err
+	}
+	m.publish(event)
+
+	return before, cluster, nil
+}
+
+func findNode(cluster *types.Cluster, nodeID string) int {
+	for i, n := range cluster.Nodes {
+		if n.ID == nodeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Refresh - moves clusters whose members stopped heartbeating or whose TTL
+// passed into their current phase, number of transitions is returned
+func (m *DefaultManager) Refresh(now time.Time) (int, error) {
+	ctx := context.Background()
+
+	clusters, err := m.list(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	transitions := 0
+	for _, cluster := range clusters {
+		// most clusters stay in their phase, only those that don't are
+		// locked and read again
+		if cluster.DeletedAt != nil || m.updatePhase(cluster, now) == nil {
+			continue
+		}
+
+		changed, err := m.refresh(ctx, cluster.ID, now)
+		if err != nil {
+			return transitions, err
+		}
+		if changed {
+			transitions++
+		}
+	}
+	return transitions, nil
+}
+
+// refresh - moves cluster into its current phase, whether it changed phase
+// is returned
+func (m *DefaultManager) refresh(ctx context.Context, id string, now time.Time) (bool, error) {
+	unlock := m.locks.lock(id)
+	defer unlock()
+
+	cluster, err := m.get(ctx, id)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	if err != nil || cluster.DeletedAt != nil {
+		return false, err
+	}
+
+	event := m.updatePhase(cluster, now)
+	if event == nil {
+		return false, nil
+	}
+	cluster.UpdatedAt = now
+
+	if err := m.put(ctx, cluster); err != nil {
+		return false, err
+	}
+	m.publish(event)
+	return true, nil
+}
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/storageos/discovery/types"
+)
+
+// reservation errors
+var (
+	ErrInvalidReservation = errors.New("invalid slot reservation")
+	ErrSlotReserved       = errors.New("node slot is reserved")
+	ErrInvalidSlotToken   = errors.New("invalid slot token")
+	ErrNoFreeSlot         = errors.New("no unreserved node slot left")
+)
+
+// reservations - validates slots reserved for a new cluster and generates
+// the requested tokens. Returned are the reservations to store, holding
+// only token hashes, and the ones handed to the creator with the tokens
+// but without their hashes.
+func reservations(requested []types.Reservation, size int, allowLoopback bool) (stored, created []types.Reservation, err error) {
+	if len(requested) > size {
+		return nil, nil, fmt.Errorf("%w: %d reservations for %d nodes", ErrInvalidReservation, len(requested), size)
+	}
+
+	names := map[string]bool{}
+	var addresses []string
+	for i, r := range requested {
+		if r.Name == "" && r.AdvertiseAddress == "" && !r.RequireToken {
+			return nil, nil, fmt.Errorf("%w: reservation %d needs a name, address or token", ErrInvalidReservation, i)
+		}
+		if r.Name != "" {
+			if names[r.Name] {
+				return nil, nil, fmt.Errorf("%w: name %s reserved twice", ErrInvalidReservation, r.Name)
+			}
+			names[r.Name] = true
+		}
+		if r.AdvertiseAddress != "" {
+			if _, err := NormalizeAddress(r.AdvertiseAddress, allowLoopback); err != nil {
+				return nil, nil, fmt.Errorf("%w: %s", ErrInvalidReservation, err)
+			}
+			for _, a := range addresses {
+				if sameAddress(a, r.AdvertiseAddress) {
+					return nil, nil, fmt.Errorf("%w: address %s reserved twice", ErrInvalidReservation, r.AdvertiseAddress)
+				}
+			}
+			addresses = append(addresses, r.AdvertiseAddress)
+		}
+
+		slot := types.Reservation{Name: r.Name, AdvertiseAddress: r.AdvertiseAddress, RequireToken: r.RequireToken}
+		c := slot
+		if r.RequireToken {
+			token, err := newSlotToken()
+			if err != nil {
+				return nil, nil, err
+			}
+			slot.TokenHash = hashSlotToken(token)
+			c.Token = token
+		}
+		stored = append(stored, slot)
+		created = append(created, c)
+	}
+	return stored, created, nil
+}
+
+func newSlotToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func hashSlotToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// slotMatches - whether the node fits the reservation, nodes presenting a
+// token only fit slots reserved with one. Tokens can be used once, their
+// hash is cleared when the slot is claimed.
+func slotMatches(r *types.Reservation, node *types.Node) bool {
+	if r.NodeID != "" && r.NodeID != node.ID {
+		return false
+	}
+	if r.Name != "" && r.Name != node.Name {
+		return false
+	}
+	if r.AdvertiseAddress != "" && !sameAddress(r.AdvertiseAddress, node.AdvertiseAddress) {
+		return false
+	}
+	if r.RequireToken != (node.SlotToken != "") {
+		return false
+	}
+	return !r.RequireToken || r.TokenHash != "" && subtle.ConstantTimeCompare([]byte(hashSlotToken(node.SlotToken)), []byte(r.TokenHash)) == 1
+}
+
+// reservedFor - whether the node's name or address is reserved for a
+// different node
+func reservedFor(cluster *types.Cluster, node *types.Node) bool {
+	for _, r := range cluster.Reservations {
+		if r.NodeID != "" && r.NodeID == node.ID {
+			continue
+		}
+		if r.Name != "" && r.Name == node.Name || r.AdvertiseAddress != "" && sameAddress(r.AdvertiseAddress, node.AdvertiseAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimSlot - assigns a slot to a node joining the cluster. Nodes fitting
+// a free reservation take it, other nodes take one of the unreserved slots
+// unless they are all gone. Slots are held by node ID, so nodes without one
+// can't join clusters with reservations.
+func claimSlot(cluster *types.Cluster, node *types.Node) error {
+	if len(cluster.Reservations) == 0 {
+		return nil
+	}
+	if node.ID == "" {
+		return ErrIDMissing
+	}
+
+	for i := range cluster.Reservations {
+		if r := &cluster.Reservations[i]; slotMatches(r, node) {
+			r.NodeID = node.ID
+			r.TokenHash = ""
+			return nil
+		}
+	}
+
+	if node.SlotToken != "" {
+		return ErrInvalidSlotToken
+	}
+	if reservedFor(cluster, node) {
+		return fmt.Errorf("%w: name %s or address %s", ErrSlotReserved, node.Name, node.AdvertiseAddress)
+	}
+
+	unreserved := 0
+	for _, n := range cluster.Nodes {
+		if slotOf(cluster, n.ID) < 0 {
+			unreserved++
+		}
+	}
+	if unreserved >= cluster.Size-len(cluster.Reservations) {
+		return ErrNoFreeSlot
+	}
+	return nil
+}
+
+// slotOf - index of the reservation held by the node, -1 if it holds none
+func slotOf(cluster *types.Cluster, nodeID string) int {
+	if nodeID == "" {
+		return -1
+	}
+	for i, r := range cluster.Reservations {
+		if r.NodeID == nodeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// slotKept - checks that an updated node still fits the slot it holds and
+// doesn't take a name or address reserved for another node
+func slotKept(cluster *types.Cluster, node *types.Node) error {
+	if idx := slotOf(cluster, node.ID); idx >= 0 {
+		r := cluster.Reservations[idx]
+		if r.Name != "" && r.Name != node.Name || r.AdvertiseAddress != "" && !sameAddress(r.AdvertiseAddress, node.AdvertiseAddress) {
+			return fmt.Errorf("%w: node has to keep name and address of its slot", ErrSlotReserved)
+		}
+	}
+	if reservedFor(cluster, node) {
+		return fmt.Errorf("%w: name %s or address %s", ErrSlotReserved, node.Name, node.AdvertiseAddress)
+	}
+	return nil
+}
+
+// releaseSlot - frees the slot held by a node leaving the cluster. The
+// token of the slot was used up, so the slot stays reserved only by name
+// and address, if any.
+func releaseSlot(cluster *types.Cluster, nodeID string) {
+	if idx := slotOf(cluster, nodeID); idx >= 0 {
+		cluster.Reservations[idx].NodeID = ""
+		cluster.Reservations[idx].RequireToken = false
+	}
+}
+
+// WithoutTokens - copy of the cluster without slot tokens and their hashes,
+// e.g. for responses and the audit log. Tokens are only handed out once
+// when the cluster is created.
+func WithoutTokens(cluster *types.Cluster) *types.Cluster {
+	if cluster == nil || len(cluster.Reservations) == 0 {
+		return cluster
+	}
+	c := *cluster
+	c.Reservations = make([]types.Reservation, len(cluster.Reservations))
+	for i, r := range cluster.Reservations {
+		r.Token = ""
+		r.TokenHash = ""
+		c.Reservations[i] = r
+	}
+	return &c
+}
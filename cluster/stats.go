@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+)
+
+// Stats - point in time view of the clusters and the background reaper
+type Stats struct {
+	// Clusters excludes deleted clusters
+	Clusters        int
+	ClustersByPhase map[types.ClusterPhase]int
+	Nodes           int
+	// StoreSize is -1 when the store doesn't report its size
+	StoreSize int64
+
+	ReaperRuns     uint64
+	ReapedClusters uint64
+}
+
+// reaperStats - counters updated by the reaper
+type reaperStats struct {
+	runs   uint64
+	reaped uint64
+}
+
+// Stats - counts clusters by phase and their nodes
+func (m *DefaultManager) Stats(ctx context.Context) (*Stats, error) {
+	clusters, err := m.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Clusters:        len(clusters),
+		ClustersByPhase: make(map[types.ClusterPhase]int),
+		StoreSize:       -1,
+		ReaperRuns:      atomic.LoadUint64(&m.reaper.runs),
+		ReapedClusters:  atomic.LoadUint64(&m.reaper.reaped),
+	}
+	for _, cluster := range clusters {
+		stats.ClustersByPhase[cluster.Phase]++
+		stats.Nodes += len(cluster.Nodes)
+	}
+
+	if sizer, ok := m.store.(store.Sizer); ok {
+		stats.StoreSize, err = sizer.Size()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
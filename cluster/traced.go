@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"context"
+	"io"
+
+	"github.com/storageos/discovery/tracing"
+	"github.com/storageos/discovery/types"
+)
+
+// traced - records a span for every manager call
+type traced struct {
+	manager Manager
+}
+
+// Traced - wraps manager so its calls show up in the trace of the calling
+// request
+func Traced(m Manager) Manager {
+	return &traced{manager: m}
+}
+
+func start(ctx context.Context, op string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "cluster."+op, attrs...)
+}
+
+func clusterAttr(id string) tracing.Attribute {
+	return tracing.String("cluster.id", id)
+}
+
+func nodeAttr(id string) tracing.Attribute {
+	return tracing.String("node.id", id)
+}
+
+func (t *traced) Create(ctx context.Context, opts types.ClusterCreateOps) (*types.Cluster, error) {
+	ctx, span := start(ctx, "Create", tracing.Int("cluster.size", opts.Size))
+	defer span.End()
+
+	c, err := t.manager.Create(ctx, opts)
+	span.RecordError(err)
+	if c != nil {
+		span.SetAttributes(clusterAttr(c.ID))
+	}
+	return c, err
+}
+
+func (t *traced) Get(ctx context.Context, ref string) (*types.Cluster, error) {
+	ctx, span := start(ctx, "Get", clusterAttr(ref))
+	defer span.End()
+
+	c, err := t.manager.Get(ctx, ref)
+	span.RecordError(err)
+	return c, err
+}
+
+func (t *traced) List(ctx context.Context) ([]*types.Cluster, error) {
+	ctx, span := start(ctx, "List")
+	defer span.End()
+
+	clusters, err := t.manager.List(ctx)
+	span.RecordError(err)
+	return clusters, err
+}
+
+func (t *traced) ListAccount(ctx context.Context, accountID string) ([]*types.Cluster, error) {
+	ctx, span := start(ctx, "ListAccount", tracing.String("account.id", accountID))
+	defer span.End()
+
+	clusters, err := t.manager.ListAccount(ctx, accountID)
+	span.RecordError(err)
+	return clusters, err
+}
+
+func (t *traced) Stats(ctx context.Context) (*Stats, error) {
+	ctx, span := start(ctx, "Stats")
+	defer span.End()
+
+	stats, err := t.manager.Stats(ctx)
+	span.RecordError(err)
+	return stats, err
+}
+
+func (t *traced) RegisterNode(ctx context.Context, clusterID string, node *types.Node) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "RegisterNode", clusterAttr(clusterID), tracing.String("node.name", node.Name))
+	defer span.End()
+
+	before, updated, err = t.manager.RegisterNode(ctx, clusterID, node)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) RegisterNodes(ctx context.Context, clusterID string, nodes []*types.Node) (before, updated *types.Cluster, results []types.NodeResult, err error) {
+	ctx, span := start(ctx, "RegisterNodes", clusterAttr(clusterID), tracing.Int("node.count", len(nodes)))
+	defer span.End()
+
+	before, updated, results, err = t.manager.RegisterNodes(ctx, clusterID, nodes)
+	span.RecordError(err)
+	return before, updated, results, err
+}
+
+func (t *traced) UpdateNode(ctx context.Context, clusterID, nodeID string, update *types.NodeUpdate) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "UpdateNode", clusterAttr(clusterID), nodeAttr(nodeID))
+	defer span.End()
+
+	before, updated, err = t.manager.UpdateNode(ctx, clusterID, nodeID, update)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) Heartbeat(ctx context.Context, clusterID, nodeID string) (*types.Cluster, error) {
+	ctx, span := start(ctx, "Heartbeat", clusterAttr(clusterID), nodeAttr(nodeID))
+	defer span.End()
+
+	c, err := t.manager.Heartbeat(ctx, clusterID, nodeID)
+	span.RecordError(err)
+	return c, err
+}
+
+func (t *traced) DeregisterNode(ctx context.Context, clusterID, nodeID string) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "DeregisterNode", clusterAttr(clusterID), nodeAttr(nodeID))
+	defer span.End()
+
+	before, updated, err = t.manager.DeregisterNode(ctx, clusterID, nodeID)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) ConfirmLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "ConfirmLeader", clusterAttr(clusterID), nodeAttr(nodeID))
+	defer span.End()
+
+	before, updated, err = t.manager.ConfirmLeader(ctx, clusterID, nodeID, epoch)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) HandoverLeader(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "HandoverLeader", clusterAttr(clusterID), nodeAttr(nodeID))
+	defer span.End()
+
+	before, updated, err = t.manager.HandoverLeader(ctx, clusterID, nodeID, epoch)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) Update(ctx context.Context, cluster *types.Cluster) error {
+	ctx, span := start(ctx, "Update", clusterAttr(cluster.ID))
+	defer span.End()
+
+	err := t.manager.Update(ctx, cluster)
+	span.RecordError(err)
+	return err
+}
+
+func (t *traced) UpdateCluster(ctx context.Context, id string, update *types.ClusterUpdate, version uint64) (before, updated *types.Cluster, err error) {
+	ctx, span := start(ctx, "UpdateCluster", clusterAttr(id))
+	defer span.End()
+
+	before, updated, err = t.manager.UpdateCluster(ctx, id, update, version)
+	span.RecordError(err)
+	return before, updated, err
+}
+
+func (t *traced) Delete(ctx context.Context, id string) (*types.Cluster, error) {
+	ctx, span := start(ctx, "Delete", clusterAttr(id))
+	defer span.End()
+
+	c, err := t.manager.Delete(ctx, id)
+	span.RecordError(err)
+	return c, err
+}
+
+func (t *traced) GetDeleted(ctx context.Context, id string) (*types.Cluster, error) {
+	ctx, span := start(ctx, "GetDeleted", clusterAttr(id))
+	defer span.End()
+
+	c, err := t.manager.GetDeleted(ctx, id)
+	span.RecordError(err)
+	return c, err
+}
+
+func (t *traced) Undelete(ctx context.Context, id string) (*types.Cluster, error) {
+	ctx, span := start(ctx, "Undelete", clusterAttr(id))
+	defer span.End()
+
+	c, err := t.manager.Undelete(ctx, id)
+	span.RecordError(err)
+	return c, err
+}
+
+func (t *traced) Purge(ctx context.Context, id string) error {
+	ctx, span := start(ctx, "Purge", clusterAttr(id))
+	defer span.End()
+
+	err := t.manager.Purge(ctx, id)
+	span.RecordError(err)
+	return err
+}
+
+func (t *traced) Import(ctx context.Context, clusters []*types.Cluster, policy ImportPolicy) (*ImportReport, error) {
+	ctx, span := start(ctx, "Import", tracing.Int("cluster.count", len(clusters)), tracing.String("import.policy", string(policy)))
+	defer span.End()
+
+	report, err := t.manager.Import(ctx, clusters, policy)
+	span.RecordError(err)
+	return report, err
+}
+
+func (t *traced) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	ctx, span := start(ctx, "Snapshot")
+	defer span.End()
+
+	n, err := t.manager.Snapshot(ctx, w)
+	span.RecordError(err)
+	span.SetAttributes(tracing.Int("snapshot.bytes", int(n)))
+	return n, err
+}
+
+func (t *traced) Restore(ctx context.Context, r io.Reader) error {
+	ctx, span := start(ctx, "Restore")
+	defer span.End()
+
+	err := t.manager.Restore(ctx, r)
+	span.RecordError(err)
+	return err
+}
+
+func (t *traced) Subscribe(h EventHandler) {
+	t.manager.Subscribe(h)
+}
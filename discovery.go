@@ -1,15 +1,26 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
+	"text/template"
+	"time"
 
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/bootstrap"
 	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/handlers"
+	"github.com/storageos/discovery/snapshot"
+	"github.com/storageos/discovery/store"
 	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/tracing"
 	"github.com/storageos/discovery/util/codecs"
+	"github.com/storageos/discovery/util/logging"
+	"github.com/storageos/discovery/version"
 )
 
 // DefaultPort - default port to run
@@ -21,28 +32,375 @@ const EnvPort = "PORT"
 // EnvDatabasePath - database path
 const EnvDatabasePath = "DATABASE_PATH"
 
+// EnvDatabaseCodec - serializer used for database records (gob or json)
+const EnvDatabaseCodec = "DATABASE_CODEC"
+
+// EnvAdminToken - token required by /admin endpoints, admin API is
+// disabled when empty
+const EnvAdminToken = "ADMIN_TOKEN"
+
+// EnvSnapshotDir - directory for scheduled snapshots, scheduled snapshots
+// are disabled when empty
+const EnvSnapshotDir = "SNAPSHOT_DIR"
+
+// EnvSnapshotInterval - interval between scheduled snapshots, e.g. 1h
+const EnvSnapshotInterval = "SNAPSHOT_INTERVAL"
+
+// EnvSnapshotRetain - number of scheduled snapshots to keep
+const EnvSnapshotRetain = "SNAPSHOT_RETAIN"
+
+// EnvAuditRetention - how long audit log entries are kept, e.g. 720h,
+// 0 keeps them forever
+const EnvAuditRetention = "AUDIT_RETENTION"
+
+// DefaultAuditRetention - default audit log retention
+const DefaultAuditRetention = 90 * 24 * time.Hour
+
+// EnvTrustedProxies - comma separated addresses or CIDR ranges of proxies,
+// e.g. the ingress, whose X-Forwarded-For header tells the client address
+// recorded in the audit log
+const EnvTrustedProxies = "TRUSTED_PROXIES"
+
+// EnvAllowLoopbackAddresses - accept loopback and unspecified node
+// advertise addresses when set to true, e.g. for local development
+const EnvAllowLoopbackAddresses = "ALLOW_LOOPBACK_ADDRESSES"
+
+// EnvDeleteGracePeriod - how long deleted clusters can be restored, e.g. 72h
+const EnvDeleteGracePeriod = "DELETE_GRACE_PERIOD"
+
+// EnvHeartbeatTimeout - completed clusters are degraded once a member
+// didn't heartbeat for this long, e.g. 5m, disabled when empty
+const EnvHeartbeatTimeout = "HEARTBEAT_TIMEOUT"
+
+// EnvLeaderRule - how the bootstrap leader is chosen, first (default) or
+// lowest-id
+const EnvLeaderRule = "LEADER_RULE"
+
+// EnvLeaderTimeout - how long the bootstrap leader has to confirm
+// initialization before other nodes can take over, e.g. 5m
+const EnvLeaderTimeout = "LEADER_TIMEOUT"
+
+// EnvConfigTemplateDir - directory of operator supplied *.tmpl config
+// formats, e.g. consul.tmpl is served as ?format=consul
+const EnvConfigTemplateDir = "CONFIG_TEMPLATE_DIR"
+
+// EnvLogFormat - log format, json (default) or logfmt
+const EnvLogFormat = "LOG_FORMAT"
+
+// EnvLogLevel - minimum log level, debug, info (default), warn or error
+const EnvLogLevel = "LOG_LEVEL"
+
+// EnvDiagnosticsAddr - address of the listener serving /metrics, pprof,
+// bolt stats and runtime info, set to empty to serve only /metrics on the
+// public port
+const EnvDiagnosticsAddr = "DIAGNOSTICS_ADDR"
+
+// DefaultDiagnosticsAddr - default diagnostics listener address, only
+// reachable from the host
+const DefaultDiagnosticsAddr = "localhost:9091"
+
+// EnvRequestTimeout - how long requests can take before their work is
+// cancelled, e.g. 10s, 0 disables the timeout
+const EnvRequestTimeout = "REQUEST_TIMEOUT"
+
+// EnvTracingExporter - trace exporter, stdout or otlp, tracing is disabled
+// when empty
+const EnvTracingExporter = "TRACING_EXPORTER"
+
+// EnvOTLPEndpoint - OTLP/HTTP collector address, e.g. http://localhost:4318
+const EnvOTLPEndpoint = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// EnvServiceName - service name reported with exported spans
+const EnvServiceName = "OTEL_SERVICE_NAME"
+
+// EnvTracesSampler - sampler of new traces, parentbased_always_on (default),
+// parentbased_always_off or parentbased_traceidratio
+const EnvTracesSampler = "OTEL_TRACES_SAMPLER"
+
+// EnvTracesSamplerArg - ratio of the parentbased_traceidratio sampler, e.g.
+// 0.1
+const EnvTracesSamplerArg = "OTEL_TRACES_SAMPLER_ARG"
+
+// DefaultServiceName - default service name of exported spans
+const DefaultServiceName = "discovery"
+
+// DefaultSnapshotInterval - default interval between scheduled snapshots
+const DefaultSnapshotInterval = time.Hour
+
+// DefaultSnapshotRetain - default number of scheduled snapshots to keep
+const DefaultSnapshotRetain = 24
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		case "help", "-h", "--help":
+			usage()
+			return
+		}
+	}
+
+	logger, err := logging.New(os.Stderr, os.Getenv(EnvLogFormat), os.Getenv(EnvLogLevel))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log configuration: %s\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
 	port := DefaultPort
 	if os.Getenv(EnvPort) != "" {
 		p, err := strconv.Atoi(os.Getenv(EnvPort))
 		if err != nil {
-			log.Fatalf("invalid port: %s", err)
+			fatal("invalid port", err)
 		}
 		port = p
 	}
 
 	path := "discovery.db"
-	if os.Getenv(EnvDatabasePath) != "" {		
+	if os.Getenv(EnvDatabasePath) != "" {
 		path = filepath.Join(os.Getenv(EnvDatabasePath), "discovery.db")
 	}
 
+	serializer, err := codecs.New(os.Getenv(EnvDatabaseCodec))
+	if err != nil {
+		fatal("invalid database codec", err)
+	}
+
 	db, err := boltdb.New(path)
 	if err != nil {
-		log.Fatalf("failed to init database: %s", err)
+		fatal("failed to init database", err)
+	}
+
+	deleteGracePeriod := cluster.DefaultDeleteGracePeriod
+	if os.Getenv(EnvDeleteGracePeriod) != "" {
+		deleteGracePeriod, err = time.ParseDuration(os.Getenv(EnvDeleteGracePeriod))
+		if err != nil {
+			fatal("invalid delete grace period", err)
+		}
+	}
+
+	allowLoopback := false
+	if os.Getenv(EnvAllowLoopbackAddresses) != "" {
+		allowLoopback, err = strconv.ParseBool(os.Getenv(EnvAllowLoopbackAddresses))
+		if err != nil {
+			fatal("invalid "+EnvAllowLoopbackAddresses, err)
+		}
+	}
+
+	var heartbeatTimeout time.Duration
+	if os.Getenv(EnvHeartbeatTimeout) != "" {
+		heartbeatTimeout, err = time.ParseDuration(os.Getenv(EnvHeartbeatTimeout))
+		if err != nil {
+			fatal("invalid heartbeat timeout", err)
+		}
+	}
+
+	leaderRule, err := cluster.ParseLeaderRule(os.Getenv(EnvLeaderRule))
+	if err != nil {
+		fatal("invalid "+EnvLeaderRule, err)
+	}
+
+	leaderTimeout := cluster.DefaultLeaderTimeout
+	if os.Getenv(EnvLeaderTimeout) != "" {
+		leaderTimeout, err = time.ParseDuration(os.Getenv(EnvLeaderTimeout))
+		if err != nil {
+			fatal("invalid leader timeout", err)
+		}
+	}
+
+	tracer, err := newTracer()
+	if err != nil {
+		fatal("invalid tracing configuration", err)
+	}
+	defer tracer.Stop()
+
+	accountBucket, err := db.Bucket(account.Bucket)
+	if err != nil {
+		fatal("failed to init accounts", err)
+	}
+	accountBucket.SetValidator(recordValidator(serializer, account.Record))
+	var accountStore store.Store = accountBucket
+	var clusterStore store.Store = db
+	if tracer != nil {
+		accountStore = store.Traced(accountStore, "accounts")
+		clusterStore = store.Traced(clusterStore, "clusters")
+	}
+	accounts := account.New(accountStore, serializer)
+
+	clusterManager := cluster.New(clusterStore, serializer,
+		cluster.WithDeleteGracePeriod(deleteGracePeriod),
+		cluster.WithAllowLoopback(allowLoopback),
+		cluster.WithHeartbeatTimeout(heartbeatTimeout),
+		cluster.WithLeaderRule(leaderRule),
+		cluster.WithLeaderTimeout(leaderTimeout),
+		cluster.WithQuotas(accounts),
+	)
+	clusterManager.StartReaper(time.Minute)
+	defer clusterManager.Stop()
+
+	auditRetention := DefaultAuditRetention
+	if os.Getenv(EnvAuditRetention) != "" {
+		auditRetention, err = time.ParseDuration(os.Getenv(EnvAuditRetention))
+		if err != nil {
+			fatal("invalid audit retention", err)
+		}
+	}
+
+	auditStore, err := db.Bucket(audit.Bucket)
+	if err != nil {
+		fatal("failed to init audit log", err)
+	}
+	auditStore.SetValidator(recordValidator(serializer, audit.Record))
+	auditLog := audit.New(auditStore, serializer, auditRetention)
+	auditLog.Start(time.Hour)
+	defer auditLog.Stop()
+
+	if dir := os.Getenv(EnvSnapshotDir); dir != "" {
+		scheduler, err := newSnapshotScheduler(clusterManager, dir)
+		if err != nil {
+			fatal("invalid snapshot configuration", err)
+		}
+		scheduler.Start()
+		defer scheduler.Stop()
+	}
+
+	var templates map[string]*template.Template
+	if dir := os.Getenv(EnvConfigTemplateDir); dir != "" {
+		templates, err = bootstrap.LoadTemplates(dir)
+		if err != nil {
+			fatal("failed to load config templates", err)
+		}
+		slog.Info("loaded config templates", "count", len(templates), "dir", dir)
+	}
+	renderer, err := bootstrap.New(templates)
+	if err != nil {
+		fatal("invalid config templates", err)
+	}
+
+	requestTimeout := handlers.DefaultRequestTimeout
+	if os.Getenv(EnvRequestTimeout) != "" {
+		requestTimeout, err = time.ParseDuration(os.Getenv(EnvRequestTimeout))
+		if err != nil {
+			fatal("invalid request timeout", err)
+		}
+	}
+
+	trustedProxies, err := handlers.ParseTrustedProxies(os.Getenv(EnvTrustedProxies))
+	if err != nil {
+		fatal("invalid trusted proxies", err)
+	}
+
+	diagnosticsAddr, ok := os.LookupEnv(EnvDiagnosticsAddr)
+	if !ok {
+		diagnosticsAddr = DefaultDiagnosticsAddr
+	}
+
+	var manager cluster.Manager = clusterManager
+	if tracer != nil {
+		manager = cluster.Traced(clusterManager)
+	}
+
+	srv := handlers.NewServer(port, manager,
+		handlers.WithAdminToken(os.Getenv(EnvAdminToken)),
+		handlers.WithAuditLog(auditLog),
+		handlers.WithRenderer(renderer),
+		handlers.WithAccounts(accounts),
+		handlers.WithLogger(logger),
+		handlers.WithTracer(tracer),
+		handlers.WithRequestTimeout(requestTimeout),
+		handlers.WithTrustedProxies(trustedProxies),
+		handlers.WithDiagnosticsAddr(diagnosticsAddr),
+		handlers.WithBoltStats(db),
+	)
+	fatal("server stopped", srv.Start())
+}
+
+// fatal - logs error preventing the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.FieldError, err)
+	os.Exit(1)
+}
+
+// newTracer - tracer of the configured exporter, nil when tracing is
+// disabled
+// recordValidator - rejects records of restored snapshots that don't decode
+// into the type stored under their key
+func recordValidator(serializer codecs.Serializer, record func(key string) interface{}) func(*store.KVPair) error {
+	return func(kvp *store.KVPair) error {
+		v := record(kvp.Key)
+		if v == nil {
+			return fmt.Errorf("unexpected key")
+		}
+		return serializer.Decode(kvp.Value, v)
+	}
+}
+
+func newTracer() (*tracing.Tracer, error) {
+	name := os.Getenv(EnvTracingExporter)
+	if name == "" {
+		return nil, nil
+	}
+
+	service := os.Getenv(EnvServiceName)
+	if service == "" {
+		service = DefaultServiceName
+	}
+
+	ratio, err := tracing.ParseSampler(os.Getenv(EnvTracesSampler), os.Getenv(EnvTracesSamplerArg))
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := tracing.NewExporter(name, os.Getenv(EnvOTLPEndpoint), service, version.Version, os.Stdout)
+	if err != nil {
+		return nil, err
 	}
+	slog.Info("tracing enabled", "exporter", name, "service", service, "sample_ratio", ratio)
+
+	return tracing.New(exporter, tracing.WithSampleRatio(ratio)), nil
+}
+
+func newSnapshotScheduler(source snapshot.Source, dir string) (*snapshot.Scheduler, error) {
+	interval := DefaultSnapshotInterval
+	if os.Getenv(EnvSnapshotInterval) != "" {
+		i, err := time.ParseDuration(os.Getenv(EnvSnapshotInterval))
+		if err != nil {
+			return nil, err
+		}
+		if i <= 0 {
+			return nil, fmt.Errorf("snapshot interval must be positive: %s", i)
+		}
+		interval = i
+	}
+
+	retain := DefaultSnapshotRetain
+	if os.Getenv(EnvSnapshotRetain) != "" {
+		r, err := strconv.Atoi(os.Getenv(EnvSnapshotRetain))
+		if err != nil {
+			return nil, err
+		}
+		retain = r
+	}
+
+	slog.Info("scheduled snapshots enabled", "interval", interval, "dir", dir, "retain", retain)
+
+	return snapshot.NewScheduler(source, dir, interval, retain), nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [command]
 
-	clusterManager := cluster.New(db, codecs.DefaultSerializer())
+Without a command the discovery server is started, configured through
+the %s, %s and %s environment variables.
 
-	srv := handlers.NewServer(port, clusterManager)
-	log.Fatal(srv.Start())
+Commands:
+  migrate    copy every record from one store/codec pair into another
+  snapshot   download a consistent snapshot from a running server
+  restore    validate and restore a snapshot on a running server
+`, filepath.Base(os.Args[0]), EnvPort, EnvDatabasePath, EnvDatabaseCodec)
 }
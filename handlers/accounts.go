@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+)
+
+const (
+	paramAccount string = "account"
+	paramKey     string = "key"
+)
+
+// keyIDAdmin - key ID of requests authenticated with the admin token
+const keyIDAdmin = "admin"
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// credentials - account and key ID of the request's bearer token, admin
+// requests have no account. Requests without a token have no key ID.
+func (s *Server) credentials(r *http.Request) (accountID, keyID string, err error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", "", nil
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	if s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return "", keyIDAdmin, nil
+	}
+	if s.accounts == nil {
+		return "", "", errInvalidCredentials
+	}
+
+	accountID, keyID, err = s.accounts.Authenticate(r.Context(), token)
+	if err == account.ErrInvalidKey {
+		return "", "", errInvalidCredentials
+	}
+	return accountID, keyID, err
+}
+
+// ownerOrAdmin - whether the request is authenticated as the admin or with
+// a key of the given account, the authenticated request is returned
+func (s *Server) ownerOrAdmin(w http.ResponseWriter, r *http.Request, accountID string) (*http.Request, bool) {
+	caller, keyID, err := s.credentials(r)
+	switch {
+	case err == errInvalidCredentials:
+		httperror.Error(w, r, err.Error(), http.StatusUnauthorized)
+		return r, false
+	case err != nil:
+		httperror.Internal(w, r, err)
+		return r, false
+	case keyID == "":
+		httperror.Error(w, r, "API key required", http.StatusUnauthorized)
+		return r, false
+	case keyID != keyIDAdmin && caller != accountID:
+		httperror.Error(w, r, "API key belongs to another account", http.StatusForbidden)
+		return r, false
+	}
+	return withKeyID(r, keyID), true
+}
+
+func respondJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bts)
+}
+
+// accountError - responds to account manager errors
+func accountError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == store.ErrNotFound:
+		httperror.Error(w, r, err.Error(), http.StatusNotFound)
+	case err == account.ErrAccountExists:
+		httperror.Error(w, r, err.Error(), http.StatusConflict)
+	case errors.Is(err, account.ErrInvalidQuota), errors.Is(err, account.ErrInvalidID):
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+	default:
+		httperror.Internal(w, r, err)
+	}
+}
+
+// accountsEnabled - account endpoints respond with 501 unless accounts are
+// configured
+func (s *Server) accountsEnabled(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.accounts == nil {
+			httperror.Error(w, r, "accounts disabled", http.StatusNotImplemented)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var a types.Account
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.accounts.Create(r.Context(), &a)
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusCreated, created)
+}
+
+func (s *Server) listAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := s.accounts.List(r.Context())
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, accounts)
+}
+
+func (s *Server) getAccountHandler(w http.ResponseWriter, r *http.Request) {
+	a, err := s.accounts.Get(r.Context(), getParam(paramAccount, r))
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, a)
+}
+
+func (s *Server) updateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var update types.AccountUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := s.accounts.Update(r.Context(), getParam(paramAccount, r), &update)
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, updated)
+}
+
+func (s *Server) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.accounts.Delete(r.Context(), getParam(paramAccount, r)); err != nil {
+		accountError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := s.accounts.CreateKey(r.Context(), getParam(paramAccount, r))
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusCreated, key)
+}
+
+func (s *Server) listKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.accounts.Keys(r.Context(), getParam(paramAccount, r))
+	if err != nil {
+		accountError(w, r, err)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, keys)
+}
+
+func (s *Server) deleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.accounts.DeleteKey(r.Context(), getParam(paramAccount, r), getParam(paramKey, r)); err != nil {
+		accountError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// accountClustersHandler - clusters of the account, available to the
+// account's API keys and the admin
+func (s *Server) accountClustersHandler(w http.ResponseWriter, r *http.Request) {
+	accountID := getParam(paramAccount, r)
+
+	r, ok := s.ownerOrAdmin(w, r, accountID)
+	if !ok {
+		return
+	}
+
+	if _, err := s.accounts.Get(r.Context(), accountID); err != nil {
+		accountError(w, r, err)
+		return
+	}
+
+	clusters, err := s.clusterManager.ListAccount(r.Context(), accountID)
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+	for i, c := range clusters {
+		clusters[i] = cluster.WithoutTokens(c)
+	}
+	respondJSON(w, r, http.StatusOK, clusters)
+}
+
+// clusterOwner - clusters of accounts can only be changed with the
+// account's API keys or by the admin, clusters without an account stay open
+// to anyone knowing their ID
+func (s *Server) clusterOwner(w http.ResponseWriter, r *http.Request, c *types.Cluster) (*http.Request, bool) {
+	if c == nil || c.AccountID == "" {
+		return r, true
+	}
+	return s.ownerOrAdmin(w, r, c.AccountID)
+}
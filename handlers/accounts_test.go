@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/types"
+)
+
+func TestAccountHandlers(t *testing.T) {
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	request := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if method == http.MethodPost && body == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	resp := request(http.MethodPost, "/admin/accounts", "", []byte(`{"id":"acme"}`))
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected account creation without admin token to fail, got %d", resp.Code)
+	}
+
+	for _, id := range []string{"acme", "other"} {
+		resp = request(http.MethodPost, "/admin/accounts", testAdminToken, []byte(`{"id":"`+id+`","quota":{"maxClusters":1,"maxSize":3}}`))
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("unexpected account creation code %d: %s", resp.Code, resp.Body)
+		}
+	}
+
+	keys := map[string]string{}
+	for _, id := range []string{"acme", "other"} {
+		resp = request(http.MethodPost, "/admin/accounts/"+id+"/keys", testAdminToken, nil)
+		if resp.Code != http.StatusCreated {
+			t.Fatalf("unexpected key creation code %d: %s", resp.Code, resp.Body)
+		}
+		var key types.APIKey
+		if err := json.Unmarshal(resp.Body.Bytes(), &key); err != nil {
+			t.Fatalf("failed to decode key: %s", err)
+		}
+		keys[id] = key.Key
+	}
+
+	resp = request(http.MethodPost, "/clusters?size=5", keys["acme"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected cluster above size quota to be rejected, got %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = request(http.MethodPost, "/clusters?size=3", keys["acme"], nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("unexpected cluster creation code %d: %s", resp.Code, resp.Body)
+	}
+	var created types.Cluster
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil {
+		t.Fatalf("failed to decode cluster: %s", err)
+	}
+	if created.AccountID != "acme" {
+		t.Errorf("expected cluster to belong to acme, got %q", created.AccountID)
+	}
+
+	resp = request(http.MethodPost, "/clusters", keys["acme"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected cluster above count quota to be rejected, got %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = request(http.MethodPost, "/clusters", "invalid.key", nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected invalid key to be rejected, got %d", resp.Code)
+	}
+
+	resp = request(http.MethodGet, "/accounts/acme/clusters", keys["acme"], nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected account clusters code %d: %s", resp.Code, resp.Body)
+	}
+	var clusters []*types.Cluster
+	if err := json.Unmarshal(resp.Body.Bytes(), &clusters); err != nil {
+		t.Fatalf("failed to decode clusters: %s", err)
+	}
+	if len(clusters) != 1 || clusters[0].ID != created.ID {
+		t.Errorf("expected the account's cluster, got %d clusters", len(clusters))
+	}
+
+	// tenants can't see or change each other's clusters
+	resp = request(http.MethodGet, "/accounts/acme/clusters", keys["other"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected listing another account's clusters to be forbidden, got %d", resp.Code)
+	}
+	resp = request(http.MethodPatch, "/clusters/"+created.ID, keys["other"], []byte(`{"name":"stolen"}`))
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected updating another account's cluster to be forbidden, got %d", resp.Code)
+	}
+	resp = request(http.MethodDelete, "/clusters/"+created.ID, "", nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected deleting an account's cluster without key to be rejected, got %d", resp.Code)
+	}
+
+	resp = request(http.MethodGet, "/accounts/acme/clusters", testAdminToken, nil)
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected admin to list account clusters, got %d", resp.Code)
+	}
+	resp = request(http.MethodDelete, "/clusters/"+created.ID, keys["acme"], nil)
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected owner to delete cluster, got %d: %s", resp.Code, resp.Body)
+	}
+	resp = request(http.MethodPost, "/clusters/"+created.ID+"/restore", keys["other"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected restoring another account's cluster to be forbidden, got %d", resp.Code)
+	}
+
+	// history names the actors, only the owner and the admin can read it
+	resp = request(http.MethodGet, "/clusters/"+created.ID+"/history", "", nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected history of an account's cluster without key to be rejected, got %d", resp.Code)
+	}
+	resp = request(http.MethodGet, "/clusters/"+created.ID+"/history", keys["other"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected history of another account's cluster to be forbidden, got %d", resp.Code)
+	}
+	resp = request(http.MethodGet, "/clusters/"+created.ID+"/history", keys["acme"], nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected history code %d: %s", resp.Code, resp.Body)
+	}
+	var entries []audit.Entry
+	if err := json.Unmarshal(resp.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("failed to decode history: %s", err)
+	}
+	if len(entries) != 2 || entries[0].Actor.KeyID == "" {
+		t.Errorf("expected owner to see the actors of create and delete, got: %+v", entries)
+	}
+
+	// restored clusters count against the quota again
+	resp = request(http.MethodPost, "/clusters?size=3", keys["acme"], nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("unexpected cluster creation code %d: %s", resp.Code, resp.Body)
+	}
+	resp = request(http.MethodPost, "/clusters/"+created.ID+"/restore", keys["acme"], nil)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected restoring cluster above count quota to be rejected, got %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = request(http.MethodDelete, "/admin/accounts/acme", testAdminToken, nil)
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("unexpected account deletion code %d: %s", resp.Code, resp.Body)
+	}
+	resp = request(http.MethodGet, "/accounts/acme/clusters", keys["acme"], nil)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected keys of deleted account to be revoked, got %d", resp.Code)
+	}
+}
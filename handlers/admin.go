@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
+)
+
+// adminOnly - rejects requests that don't carry the admin token, admin
+// endpoints are disabled unless the token is configured
+func (s *Server) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			httperror.Error(w, r, "admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			httperror.Error(w, r, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		h(w, withKeyID(r, keyIDAdmin))
+	}
+}
+
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="discovery-%s.db"`, time.Now().UTC().Format("20060102T150405Z")))
+
+	n, err := s.clusterManager.Snapshot(r.Context(), w)
+	if err != nil {
+		if n > 0 {
+			// headers and part of the body are already sent
+			logging.FromContext(r.Context()).Error("snapshot failed after headers were sent", "bytes", n, logging.FieldError, err)
+			return
+		}
+		w.Header().Del("Content-Disposition")
+		if err == store.ErrNotSupported {
+			httperror.Error(w, r, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+
+}
+
+// restoreHandler - restores the whole database, including accounts and the
+// audit log. Every cluster that existed before or after the restore gets a
+// restore-snapshot entry in the restored audit log.
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	before, err := s.clusterManager.List(r.Context())
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	err = s.clusterManager.Restore(r.Context(), r.Body)
+	if err != nil {
+		switch {
+		case err == store.ErrNotSupported:
+			httperror.Error(w, r, err.Error(), http.StatusNotImplemented)
+		case errors.Is(err, store.ErrInvalidSnapshot):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		default:
+			httperror.Internal(w, r, err)
+		}
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("database restored from snapshot")
+
+	after, err := s.clusterManager.List(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to list restored clusters", logging.FieldError, err)
+	}
+	s.recordRestore(r, before, after)
+
+	fmt.Fprintf(w, "OK")
+}
+
+// recordRestore - records clusters that the restore created, changed or
+// removed
+func (s *Server) recordRestore(r *http.Request, before, after []*types.Cluster) {
+	previous := make(map[string]*types.Cluster, len(before))
+	for _, c := range before {
+		previous[c.ID] = c
+	}
+	for _, c := range after {
+		s.record(r, audit.ActionRestoreSnapshot, c.ID, previous[c.ID], c)
+		delete(previous, c.ID)
+	}
+	for id, c := range previous {
+		s.record(r, audit.ActionRestoreSnapshot, id, c, nil)
+	}
+}
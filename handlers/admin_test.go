@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/types"
+)
+
+func TestSnapshotRestoreHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	adminRequest := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	kept, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := adminRequest(http.MethodGet, "/admin/snapshot", nil)
+	if snapshot.Code != http.StatusOK {
+		t.Fatalf("unexpected snapshot code %d: %s", snapshot.Code, snapshot.Body)
+	}
+
+	removed, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := adminRequest(http.MethodPost, "/admin/restore", []byte("not a snapshot"))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid snapshot to be rejected, got %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = adminRequest(http.MethodPost, "/admin/restore", snapshot.Body.Bytes())
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected restore code %d: %s", resp.Code, resp.Body)
+	}
+	if _, err := srv.server.clusterManager.Get(ctx, removed.ID); err == nil {
+		t.Errorf("expected cluster created after the snapshot to be gone")
+	}
+
+	// the audit log is restored too, the restore itself is recorded for
+	// every cluster it touched
+	entries, err := srv.server.auditLog.Query(ctx, audit.Query{Action: audit.ActionRestoreSnapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := map[string]*audit.Entry{}
+	for _, e := range entries {
+		restored[e.ClusterID] = e
+	}
+	if e := restored[kept.ID]; e == nil || e.Before == nil || e.After == nil || e.Actor.KeyID != keyIDAdmin {
+		t.Errorf("expected restore of the kept cluster to be recorded, got %+v", e)
+	}
+	if e := restored[removed.ID]; e == nil || e.Before == nil || e.After != nil {
+		t.Errorf("expected removal of the newer cluster to be recorded, got %+v", e)
+	}
+}
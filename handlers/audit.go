@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
+)
+
+const headerRequestID = "X-Request-ID"
+
+type contextKey string
+
+const contextKeyID contextKey = "keyID"
+
+// withKeyID - marks request as authenticated with the given credential
+func withKeyID(r *http.Request, keyID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), contextKeyID, keyID))
+}
+
+// actor - client making the request. Discovery is usually deployed behind
+// an ingress, X-Forwarded-For is only honoured for requests coming from a
+// trusted proxy though as clients can send anything. The client is the
+// last address of the chain that isn't a trusted proxy itself.
+func (s *Server) actor(r *http.Request) audit.Actor {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if s.trustedProxy(ip) {
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip = hop
+			if !s.trustedProxy(hop) {
+				break
+			}
+		}
+	}
+
+	keyID, _ := r.Context().Value(contextKeyID).(string)
+
+	return audit.Actor{IP: ip, KeyID: keyID}
+}
+
+func (s *Server) trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range s.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies - parses comma separated proxy addresses or CIDR
+// ranges, e.g. 10.0.0.0/8,192.168.1.10
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %s", v)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// record - appends mutation to the audit log, failures are logged but
+// don't fail the request as the mutation already happened
+func (s *Server) record(r *http.Request, action audit.Action, clusterID string, before, after *types.Cluster) {
+	if s.auditLog == nil {
+		return
+	}
+
+	// the mutation happened even if the client went away meanwhile
+	err := s.auditLog.Record(context.WithoutCancel(r.Context()), &audit.Entry{
+		ClusterID: clusterID,
+		Action:    action,
+		Actor:     s.actor(r),
+		RequestID: r.Header.Get(headerRequestID),
+		Before:    cluster.WithoutTokens(before),
+		After:     cluster.WithoutTokens(after),
+	})
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to record audit log entry", "action", action, logging.FieldClusterID, clusterID, logging.FieldError, err)
+	}
+}
+
+// historyHandler - history of account clusters is only available to the
+// owner and the admin. Open clusters' history leaves out the actors as
+// anyone knowing the cluster ID can read it.
+func (s *Server) historyHandler(w http.ResponseWriter, r *http.Request) {
+	if s.auditLog == nil {
+		httperror.Error(w, r, "audit log disabled", http.StatusNotImplemented)
+		return
+	}
+
+	clusterID := getParam(paramCluster, r)
+	entries, err := s.auditLog.Query(r.Context(), audit.Query{ClusterID: clusterID})
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	r, ok := s.clusterOwner(w, r, s.historyOwner(r, clusterID, entries))
+	if !ok {
+		return
+	}
+
+	if _, authenticated := r.Context().Value(contextKeyID).(string); !authenticated {
+		for _, e := range entries {
+			e.Actor = audit.Actor{}
+		}
+	}
+
+	respondJSON(w, r, http.StatusOK, entries)
+}
+
+// historyOwner - cluster the history belongs to, purged clusters are only
+// known from their most recent entry
+func (s *Server) historyOwner(r *http.Request, clusterID string, entries []*audit.Entry) *types.Cluster {
+	if current, err := s.clusterManager.Get(r.Context(), clusterID); err == nil {
+		return current
+	}
+	if deleted, err := s.clusterManager.GetDeleted(r.Context(), clusterID); err == nil {
+		return deleted
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].After != nil {
+			return entries[i].After
+		}
+		if entries[i].Before != nil {
+			return entries[i].Before
+		}
+	}
+	return nil
+}
+
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	q := audit.Query{
+		ClusterID: r.FormValue("cluster"),
+		Action:    audit.Action(r.FormValue("action")),
+		ActorIP:   r.FormValue("ip"),
+	}
+
+	var err error
+	if v := r.FormValue("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			httperror.Error(w, r, "invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			httperror.Error(w, r, "invalid until: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			httperror.Error(w, r, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s.auditQuery(w, r, q)
+}
+
+func (s *Server) auditQuery(w http.ResponseWriter, r *http.Request, q audit.Query) {
+	if s.auditLog == nil {
+		httperror.Error(w, r, "audit log disabled", http.StatusNotImplemented)
+		return
+	}
+
+	entries, err := s.auditLog.Query(r.Context(), q)
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	bts, err := json.Marshal(entries)
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAuditActor(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/8,ingress"); err == nil {
+		t.Errorf("expected invalid proxy address to be rejected")
+	}
+	s := &Server{trustedProxies: proxies}
+
+	for _, tc := range []struct {
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		// untrusted clients can't claim another address
+		{remote: "203.0.113.7:4000", forwarded: []string{"198.51.100.1"}, ip: "203.0.113.7"},
+		{remote: "203.0.113.7:4000", ip: "203.0.113.7"},
+		{remote: "10.1.2.3:4000", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		// addresses prepended by the client are skipped
+		{remote: "10.1.2.3:4000", forwarded: []string{"198.51.100.1, 203.0.113.7"}, ip: "203.0.113.7"},
+		{remote: "10.1.2.3:4000", forwarded: []string{"198.51.100.1", "203.0.113.7, 192.168.1.10"}, ip: "203.0.113.7"},
+		{remote: "192.168.1.11:4000", forwarded: []string{"198.51.100.1"}, ip: "192.168.1.11"},
+	} {
+		r, err := http.NewRequest(http.MethodGet, "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.actor(r).IP; got != tc.ip {
+			t.Errorf("%s forwarding %v: expected actor %s, got %s", tc.remote, tc.forwarded, tc.ip, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+)
+
+// etag - entity tag of the cluster record version
+func etag(c *types.Cluster) string {
+	return fmt.Sprintf(`"%d"`, c.Version)
+}
+
+// setValidators - sets ETag and Last-Modified headers of the cluster
+func setValidators(w http.ResponseWriter, c *types.Cluster) {
+	w.Header().Set("ETag", etag(c))
+	if !c.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", c.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified - whether the client's cached copy is still current.
+// If-None-Match takes precedence over If-Modified-Since, as the version
+// changes with every write while Last-Modified only has second precision.
+func notModified(r *http.Request, c *types.Cluster) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		current := etag(c)
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == current {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !c.UpdatedAt.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !c.UpdatedAt.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// parseIfMatch - version required by the If-Match header, 0 when the
+// header is missing or "*"
+func parseIfMatch(r *http.Request) (uint64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseUint(strings.Trim(value, `"`), 10, 64)
+	if err != nil || version == 0 || !strings.HasPrefix(value, `"`) || !strings.HasSuffix(value, `"`) {
+		return 0, fmt.Errorf("invalid If-Match header %s, expected a single strong ETag", value)
+	}
+	return version, nil
+}
+
+func (s *Server) updateClusterHandler(w http.ResponseWriter, r *http.Request) {
+	clusterID := getParam(paramCluster, r)
+
+	version, err := parseIfMatch(r)
+	if err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusPreconditionFailed)
+		return
+	}
+
+	var update types.ClusterUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, _ := s.clusterManager.Get(r.Context(), clusterID)
+	r, ok := s.clusterOwner(w, r, current)
+	if !ok {
+		return
+	}
+
+	before, updated, err := s.clusterManager.UpdateCluster(r.Context(), clusterID, &update, version)
+	if err != nil {
+		switch {
+		case err == store.ErrNotFound:
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+		case err == cluster.ErrVersionMismatch:
+			if current != nil {
+				w.Header().Set("ETag", etag(current))
+			}
+			httperror.Error(w, r, err.Error(), http.StatusPreconditionFailed)
+		case err == cluster.ErrClusterExpired:
+			httperror.Error(w, r, err.Error(), http.StatusGone)
+		case err == cluster.ErrInvalidSize, err == cluster.ErrInvalidTTL:
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, cluster.ErrQuotaExceeded):
+			httperror.Error(w, r, err.Error(), http.StatusForbidden)
+		case errors.Is(err, cluster.ErrSizeTooSmall):
+			httperror.Error(w, r, err.Error(), http.StatusUnprocessableEntity)
+		default:
+			httperror.Internal(w, r, err)
+		}
+		return
+	}
+
+	s.record(r, audit.ActionUpdate, clusterID, before, updated)
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	setValidators(w, updated)
+	w.Write(bts)
+}
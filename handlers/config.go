@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/storageos/discovery/bootstrap"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/store"
+)
+
+// configHandler - renders cluster membership in the requested format,
+// nodes can be filtered the same way as in cluster status
+func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = bootstrap.FormatJSON
+	}
+
+	filter := cluster.NodeFilter{Role: r.FormValue("role")}
+	for _, selector := range r.Form["label"] {
+		if err := filter.ParseLabelSelector(selector); err != nil {
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := s.clusterManager.Get(r.Context(), getParam(paramCluster, r))
+	if err != nil {
+		if err == store.ErrNotFound {
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	if filter.Role != "" || len(filter.Labels) > 0 {
+		c.Nodes = cluster.FilterNodes(c.Nodes, filter)
+	}
+
+	// rendered into a buffer so a failing template doesn't leave a
+	// partial response
+	var buf bytes.Buffer
+	if err := s.renderer.Render(&buf, format, c); err != nil {
+		if errors.Is(err, bootstrap.ErrUnknownFormat) {
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", s.renderer.ContentType(format))
+	w.Write(buf.Bytes())
+}
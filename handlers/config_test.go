@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/storageos/discovery/types"
+)
+
+func TestConfigHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*types.Node{
+		{ID: "1", Name: "node1", AdvertiseAddress: "http://192.168.0.1:2380", Role: types.NodeRoleController},
+		{ID: "2", Name: "node2", AdvertiseAddress: "http://192.168.0.2:2380", Role: types.NodeRoleStorage},
+	} {
+		if _, _, err := srv.server.clusterManager.RegisterNode(ctx, c.ID, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"?format=etcd", http.StatusOK, "node1=http://192.168.0.1:2380,node2=http://192.168.0.2:2380\n"},
+		{"?format=etcd&role=controller", http.StatusOK, "node1=http://192.168.0.1:2380\n"},
+		{"?format=hosts", http.StatusOK, "192.168.0.1\tnode1\n192.168.0.2\tnode2\n"},
+		{"?format=unknown", http.StatusBadRequest, ""},
+		{"", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, "/clusters/"+c.ID+"/config"+tt.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+
+		if resp.Code != tt.code {
+			t.Errorf("%s: got code %d, wanted %d", tt.query, resp.Code, tt.code)
+			continue
+		}
+		if tt.body != "" && resp.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, wanted %q", tt.query, resp.Body.String(), tt.body)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/clusters/unknown/config?format=etcd", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	srv.server.mux.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown cluster, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
+	"github.com/storageos/discovery/version"
+)
+
+// BoltStatser - database reporting bolt statistics
+type BoltStatser interface {
+	Stats() bolt.Stats
+}
+
+// WithDiagnosticsAddr - serves /metrics, pprof, bolt stats and runtime info
+// on a separate listener at addr, e.g. localhost:9091, instead of the
+// public port. Without it only /metrics is served, on the public port.
+func WithDiagnosticsAddr(addr string) Option {
+	return OptionFn(func(s *Server) error {
+		s.diagnosticsAddr = addr
+		return nil
+	})
+}
+
+// WithBoltStats - database reported by the /debug/bolt diagnostics endpoint
+func WithBoltStats(db BoltStatser) Option {
+	return OptionFn(func(s *Server) error {
+		s.bolt = db
+		return nil
+	})
+}
+
+func (s *Server) registerDiagnostics() {
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/bolt", s.boltStatsHandler)
+	mux.HandleFunc("/debug/runtime", s.runtimeHandler)
+
+	s.diagnostics = s.observeDiagnostics(mux)
+}
+
+// observeDiagnostics - counts diagnostics requests in the same series as
+// API requests, the matched pattern serves as route template
+func (s *Server) observeDiagnostics(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeUnmatched
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		mux.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		s.metrics.observe(route, r.Method, sw.code, time.Since(start))
+	})
+}
+
+// startDiagnostics - serves diagnostics in the background, requests aren't
+// time limited as CPU profiles and traces take as long as the client asks
+func (s *Server) startDiagnostics() {
+	s.diagnosticsServer = &http.Server{
+		Addr:              s.diagnosticsAddr,
+		Handler:           s.diagnostics,
+		IdleTimeout:       time.Second * 120,
+		ReadHeaderTimeout: time.Second * 30,
+	}
+	s.logger.Info("diagnostics server starting", "addr", s.diagnosticsAddr)
+
+	go func() {
+		err := s.diagnosticsServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Error("diagnostics server stopped", logging.FieldError, err)
+		}
+	}()
+}
+
+func (s *Server) boltStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.bolt == nil {
+		httperror.Error(w, r, "bolt stats not available", http.StatusNotImplemented)
+		return
+	}
+	respondJSON(w, r, http.StatusOK, s.bolt.Stats())
+}
+
+// runtimeInfo - build and Go runtime details of the running server
+type runtimeInfo struct {
+	Version    types.VersionInfo `json:"version"`
+	GoVersion  string            `json:"goVersion"`
+	OS         string            `json:"os"`
+	Arch       string            `json:"arch"`
+	NumCPU     int               `json:"numCPU"`
+	GOMAXPROCS int               `json:"gomaxprocs"`
+	Goroutines int               `json:"goroutines"`
+	StartedAt  time.Time         `json:"startedAt"`
+	Uptime     string            `json:"uptime"`
+	Memory     memoryInfo        `json:"memory"`
+}
+
+type memoryInfo struct {
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	Sys          uint64 `json:"sys"`
+	NumGC        uint32 `json:"numGC"`
+	PauseTotalNs uint64 `json:"pauseTotalNs"`
+}
+
+func (s *Server) runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	respondJSON(w, r, http.StatusOK, &runtimeInfo{
+		Version:    version.GetVersion(),
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		StartedAt:  s.startedAt,
+		Uptime:     time.Since(s.startedAt).Round(time.Second).String(),
+		Memory: memoryInfo{
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			Sys:          m.Sys,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+	})
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDiagnostics(t *testing.T) {
+	ts := setupTestServer(t)
+	defer teardownTestServer(t, ts)
+
+	srv := NewServer(1, ts.server.clusterManager,
+		WithDiagnosticsAddr("localhost:9091"),
+		WithBoltStats(ts.store),
+		WithRegistry(prometheus.NewRegistry()),
+	)
+
+	request := func(h http.Handler, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if resp := request(srv.mux, "/metrics"); resp.Code != http.StatusNotFound {
+		t.Errorf("expected metrics to be removed from the public port, got %d", resp.Code)
+	}
+	if resp := request(srv.mux, "/debug/pprof/"); resp.Code != http.StatusNotFound {
+		t.Errorf("expected pprof not to be served on the public port, got %d", resp.Code)
+	}
+
+	for _, path := range []string{"/metrics", "/debug/pprof/", "/debug/pprof/goroutine?debug=1"} {
+		if resp := request(srv.diagnostics, path); resp.Code != http.StatusOK {
+			t.Errorf("unexpected %s code %d", path, resp.Code)
+		}
+	}
+
+	resp := request(srv.diagnostics, "/debug/bolt")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected bolt stats code %d: %s", resp.Code, resp.Body)
+	}
+	var stats map[string]interface{}
+	if err := json.Unmarshal(resp.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["TxStats"]; !ok {
+		t.Errorf("expected transaction stats: %s", resp.Body)
+	}
+
+	resp = request(srv.diagnostics, "/debug/runtime")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected runtime code %d: %s", resp.Code, resp.Body)
+	}
+	var info runtimeInfo
+	if err := json.Unmarshal(resp.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Goroutines == 0 || info.GoVersion == "" || info.Memory.Sys == 0 {
+		t.Errorf("unexpected runtime info: %s", resp.Body)
+	}
+
+	resp = request(srv.diagnostics, "/metrics")
+	if !strings.Contains(resp.Body.String(), `http_requests_total{code="200",method="GET",route="/debug/runtime"}`) {
+		t.Errorf("expected diagnostics requests to be counted per route")
+	}
+
+	// without bolt stats
+	srv = NewServer(1, ts.server.clusterManager, WithRegistry(prometheus.NewRegistry()))
+	if resp := request(srv.diagnostics, "/debug/bolt"); resp.Code != http.StatusNotImplemented {
+		t.Errorf("expected bolt stats to be unavailable, got %d", resp.Code)
+	}
+	if resp := request(srv.mux, "/metrics"); resp.Code != http.StatusOK {
+		t.Errorf("expected metrics on the public port without diagnostics listener, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/util/logging"
+)
+
+// phaseChanged - counts phase transitions and records them in the audit log
+func (s *Server) phaseChanged(e cluster.Event) {
+	s.metrics.phaseTransitions.WithLabelValues(string(e.From), string(e.To)).Inc()
+
+	if s.auditLog == nil {
+		return
+	}
+
+	err := s.auditLog.Record(context.Background(), &audit.Entry{
+		ClusterID: e.ClusterID,
+		Action:    audit.ActionPhase,
+		Changes:   []audit.Change{{Field: "phase", From: string(e.From), To: string(e.To)}},
+		Timestamp: e.Timestamp,
+	})
+	if err != nil {
+		s.logger.Error("failed to record phase change in audit log", logging.FieldClusterID, e.ClusterID, logging.FieldError, err)
+	}
+}
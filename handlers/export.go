@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
+)
+
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	clusters, err := s.clusterManager.List(r.Context())
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	// one cluster document per line
+	enc := json.NewEncoder(w)
+	for _, c := range clusters {
+		if err := enc.Encode(c); err != nil {
+			logging.FromContext(r.Context()).Error("export failed", logging.FieldClusterID, c.ID, logging.FieldError, err)
+			return
+		}
+	}
+
+}
+
+func (s *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	policy := cluster.ImportFail
+	if p := r.FormValue("policy"); p != "" {
+		policy = cluster.ImportPolicy(p)
+	}
+
+	var clusters []*types.Cluster
+	byID := map[string]*types.Cluster{}
+	dec := json.NewDecoder(r.Body)
+	for {
+		var c types.Cluster
+		err := dec.Decode(&c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			httperror.Error(w, r, "invalid cluster document: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		clusters = append(clusters, &c)
+		byID[c.ID] = &c
+	}
+
+	before := map[string]*types.Cluster{}
+	for id := range byID {
+		if existing, err := s.clusterManager.Get(r.Context(), id); err == nil {
+			before[id] = existing
+		}
+	}
+
+	report, err := s.clusterManager.Import(r.Context(), clusters, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, cluster.ErrClusterExists):
+			httperror.Error(w, r, err.Error(), http.StatusConflict)
+		case err == cluster.ErrUnknownImportPolicy:
+			httperror.Error(w, r, err.Error()+": "+string(policy), http.StatusBadRequest)
+		case errors.As(err, new(*cluster.ImportError)):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		default:
+			httperror.Internal(w, r, err)
+		}
+		return
+	}
+
+	for _, id := range append(report.Created, report.Overwritten...) {
+		s.record(r, audit.ActionImport, id, before[id], byID[id])
+	}
+
+	logging.FromContext(r.Context()).Info("imported clusters",
+		"created", len(report.Created), "overwritten", len(report.Overwritten), "skipped", len(report.Skipped))
+
+	bts, err := json.Marshal(report)
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
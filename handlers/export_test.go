@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/storageos/discovery/types"
+)
+
+func TestExportImportHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	for i := 0; i < 3; i++ {
+		c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = srv.server.clusterManager.RegisterNode(ctx, c.ID, &types.Node{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	adminRequest := func(srv *Server, method, path string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	exported := adminRequest(srv.server, http.MethodGet, "/admin/export", nil)
+	if exported.Code != http.StatusOK {
+		t.Fatalf("unexpected export code %d: %s", exported.Code, exported.Body)
+	}
+	if lines := strings.Count(exported.Body.String(), "\n"); lines != 3 {
+		t.Errorf("expected 3 exported documents, got %d", lines)
+	}
+
+	clusters, err := srv.server.clusterManager.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clusters {
+		if err := srv.server.clusterManager.Purge(ctx, c.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := adminRequest(srv.server, http.MethodPost, "/admin/import", exported.Body.Bytes())
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected import code %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = adminRequest(srv.server, http.MethodPost, "/admin/import?policy=fail", exported.Body.Bytes())
+	if resp.Code != http.StatusConflict {
+		t.Errorf("expected conflict on repeated import, got %d: %s", resp.Code, resp.Body)
+	}
+
+	resp = adminRequest(srv.server, http.MethodPost, "/admin/import?policy=skip", exported.Body.Bytes())
+	if resp.Code != http.StatusOK {
+		t.Errorf("unexpected import code with skip policy %d: %s", resp.Code, resp.Body)
+	}
+
+	clusters, err = srv.server.clusterManager.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 3 {
+		t.Errorf("expected 3 imported clusters, got %d", len(clusters))
+	}
+
+	resp = adminRequest(srv.server, http.MethodPost, "/admin/import?policy=overwrite",
+		[]byte(`{"id":"bad","size":3,"nodes":[{"id":"1","advertiseAddress":"192.168.0.1"}]}`))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid node to be rejected, got %d: %s", resp.Code, resp.Body)
+	}
+}
@@ -2,44 +2,28 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
-	"github.com/prometheus/client_golang/prometheus"
-
 	"github.com/storageos/discovery/handlers/httperror"
 	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
 )
 
-var healthCounter *prometheus.CounterVec
-
-func init() {
-	healthCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "endpoint_health_requests_total",
-			Help: "How many /health requests processed, partitioned by status code and HTTP method.",
-		},
-		[]string{"code", "method"},
-	)
-	prometheus.MustRegister(healthCounter)
-}
-
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	cluster, err := s.clusterManager.Create(types.ClusterCreateOps{})
+	cluster, err := s.clusterManager.Create(r.Context(), types.ClusterCreateOps{})
 
 	if err != nil {
-		log.Printf("health failed to create cluster %v", err)
-		httperror.Error(w, r, "health failed to create cluster", 400, healthCounter)
+		logging.FromContext(r.Context()).Error("health failed to create cluster", logging.FieldError, err)
+		httperror.Error(w, r, "health failed to create cluster", 400)
 		return
 	}
 
-	err = s.clusterManager.Delete(cluster.ID)
+	err = s.clusterManager.Purge(r.Context(), cluster.ID)
 	if err != nil {
-		log.Printf("health failed to delete cluster %v", err)
-		httperror.Error(w, r, "health failed to delete cluster", 400, healthCounter)
+		logging.FromContext(r.Context()).Error("health failed to delete cluster", logging.FieldClusterID, cluster.ID, logging.FieldError, err)
+		httperror.Error(w, r, "health failed to delete cluster", 400)
 		return
 	}
 
 	fmt.Fprintf(w, "OK")
-	healthCounter.WithLabelValues("200", r.Method).Add(1)
 }
@@ -3,40 +3,171 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
 	"time"
 
-	gorillaHandlers "github.com/gorilla/handlers"
-
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/bootstrap"
 	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/tracing"
 
 	"github.com/gorilla/mux"
 )
 
+// DefaultRequestTimeout - default time requests have to complete, below
+// the server's write timeout so timed out requests still get a response
+const DefaultRequestTimeout = 20 * time.Second
+
 // Server - main discovery service server container
 type Server struct {
 	clusterManager cluster.Manager
 	port           int
 	server         *http.Server
-	mux            *mux.Router
+	mux            http.Handler
+	adminToken     string
+	auditLog       *audit.Log
+	renderer       *bootstrap.Renderer
+	accounts       *account.Manager
+	registry       *prometheus.Registry
+	metrics        *metrics
+	logger         *slog.Logger
+	tracer         *tracing.Tracer
+	startedAt      time.Time
+	requestTimeout time.Duration
+	trustedProxies []*net.IPNet
+
+	// diagnostics listener, disabled unless an address is configured
+	diagnosticsAddr   string
+	diagnostics       http.Handler
+	diagnosticsServer *http.Server
+	bolt              BoltStatser
 }
 
 // NewServer - new discovery http server
-func NewServer(port int, cm cluster.Manager) *Server {
+func NewServer(port int, cm cluster.Manager, options ...Option) *Server {
 	srv := &Server{
 		clusterManager: cm,
 		port:           port,
+		startedAt:      time.Now(),
+		requestTimeout: DefaultRequestTimeout,
+	}
+
+	for _, opt := range options {
+		opt.Configure(srv)
+	}
+
+	if srv.renderer == nil {
+		// built-in formats only, can't fail without templates
+		srv.renderer, _ = bootstrap.New(nil)
+	}
+
+	if srv.registry == nil {
+		srv.registry = newRegistry()
 	}
+	if srv.logger == nil {
+		srv.logger = slog.Default()
+	}
+	srv.metrics = newMetrics(srv.registry, cm, srv.logger)
 
+	cm.Subscribe(srv.phaseChanged)
 	srv.registerHandlers()
+	srv.registerDiagnostics()
 
 	return srv
 }
 
+// WithAdminToken - enables /admin endpoints, requests have to carry the
+// token in an "Authorization: Bearer <token>" header
+func WithAdminToken(token string) Option {
+	return OptionFn(func(s *Server) error {
+		s.adminToken = token
+		return nil
+	})
+}
+
+// WithAuditLog - records cluster mutations in the audit log
+func WithAuditLog(l *audit.Log) Option {
+	return OptionFn(func(s *Server) error {
+		s.auditLog = l
+		return nil
+	})
+}
+
+// WithRenderer - renders cluster config, e.g. with operator supplied
+// templates
+func WithRenderer(r *bootstrap.Renderer) Option {
+	return OptionFn(func(s *Server) error {
+		s.renderer = r
+		return nil
+	})
+}
+
+// WithAccounts - enables accounts and their API keys, clusters created with
+// an account's key belong to the account
+func WithAccounts(m *account.Manager) Option {
+	return OptionFn(func(s *Server) error {
+		s.accounts = m
+		return nil
+	})
+}
+
+// WithLogger - logs requests and errors with the given logger instead of
+// the default one
+func WithLogger(l *slog.Logger) Option {
+	return OptionFn(func(s *Server) error {
+		s.logger = l
+		return nil
+	})
+}
+
+// WithRequestTimeout - cancels work of requests taking longer, they get
+// 503 Service Unavailable. 0 disables the timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return OptionFn(func(s *Server) error {
+		s.requestTimeout = d
+		return nil
+	})
+}
+
+// WithTrustedProxies - proxies whose X-Forwarded-For header is trusted to
+// tell the client address recorded in the audit log
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return OptionFn(func(s *Server) error {
+		s.trustedProxies = proxies
+		return nil
+	})
+}
+
+// WithTracer - traces requests, spans continue the trace of the caller's
+// traceparent header
+func WithTracer(t *tracing.Tracer) Option {
+	return OptionFn(func(s *Server) error {
+		s.tracer = t
+		return nil
+	})
+}
+
+// Option is used to pass optional arguments to
+// the Server constructor
+type Option interface {
+	Configure(*Server) error
+}
+
+// OptionFn is a type of Option that is represented
+// by a single function that gets called for Configure()
+type OptionFn func(*Server) error
+
+// Configure - configures specific variable
+func (o OptionFn) Configure(s *Server) error {
+	return o(s)
+}
+
 // Start - configures and starts HTTP server
 func (s *Server) Start() error {
 
@@ -48,9 +179,11 @@ func (s *Server) Start() error {
 		ReadHeaderTimeout: time.Second * 30,
 		WriteTimeout:      time.Second * 25,
 	}
-	log.Printf("server starting on port %d", s.port)
+	s.logger.Info("server starting", "port", s.port)
 
-	s.server.Handler = gorillaHandlers.LoggingHandler(os.Stdout, s.mux)
+	if s.diagnosticsAddr != "" {
+		s.startDiagnostics()
+	}
 
 	return s.server.ListenAndServe()
 }
@@ -60,6 +193,9 @@ func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(5))
 	defer cancel()
 	s.server.Shutdown(ctx)
+	if s.diagnosticsServer != nil {
+		s.diagnosticsServer.Shutdown(ctx)
+	}
 }
 
 func getParam(param string, req *http.Request) string {
@@ -75,20 +211,46 @@ func (s *Server) registerHandlers() {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/", homeHandler)
-	r.HandleFunc("/version", versionHandler)
+	r.HandleFunc("/version", s.versionHandler)
 	r.HandleFunc("/health", s.healthHandler)
 	r.HandleFunc("/robots.txt", robotsHandler)
 
 	r.HandleFunc("/clusters", s.newClusterHandler).Methods("POST")
 	r.HandleFunc("/clusters/{ref}", s.clusterHandler).Methods("GET")
 	r.HandleFunc("/clusters/{ref}", s.registerNodeHandler).Methods("PUT")
+	r.HandleFunc("/clusters/{ref}", s.updateClusterHandler).Methods("PATCH")
 	r.HandleFunc("/clusters/{ref}", s.deleteClusterHandler).Methods("DELETE")
+	r.HandleFunc("/clusters/{ref}/history", s.historyHandler).Methods("GET")
+	r.HandleFunc("/clusters/{ref}/config", s.configHandler).Methods("GET")
+	r.HandleFunc("/clusters/{ref}/restore", s.undeleteClusterHandler).Methods("POST")
+	r.HandleFunc("/clusters/{ref}/nodes:batch", s.registerNodesHandler).Methods("POST")
+	r.HandleFunc("/clusters/{ref}/nodes/{node}", s.updateNodeHandler).Methods("PATCH")
+	r.HandleFunc("/clusters/{ref}/nodes/{node}", s.deregisterNodeHandler).Methods("DELETE")
+	r.HandleFunc("/clusters/{ref}/nodes/{node}/heartbeat", s.heartbeatHandler).Methods("POST")
+	r.HandleFunc("/clusters/{ref}/leader/confirm", s.confirmLeaderHandler).Methods("POST")
+	r.HandleFunc("/clusters/{ref}/leader/handover", s.handoverLeaderHandler).Methods("POST")
 
-	r.Handle("/metrics", promhttp.Handler())
+	r.HandleFunc("/accounts/{account}/clusters", s.accountsEnabled(s.accountClustersHandler)).Methods("GET")
 
-	logH := gorillaHandlers.LoggingHandler(os.Stdout, r)
+	r.HandleFunc("/admin/accounts", s.adminOnly(s.accountsEnabled(s.createAccountHandler))).Methods("POST")
+	r.HandleFunc("/admin/accounts", s.adminOnly(s.accountsEnabled(s.listAccountsHandler))).Methods("GET")
+	r.HandleFunc("/admin/accounts/{account}", s.adminOnly(s.accountsEnabled(s.getAccountHandler))).Methods("GET")
+	r.HandleFunc("/admin/accounts/{account}", s.adminOnly(s.accountsEnabled(s.updateAccountHandler))).Methods("PATCH")
+	r.HandleFunc("/admin/accounts/{account}", s.adminOnly(s.accountsEnabled(s.deleteAccountHandler))).Methods("DELETE")
+	r.HandleFunc("/admin/accounts/{account}/keys", s.adminOnly(s.accountsEnabled(s.createKeyHandler))).Methods("POST")
+	r.HandleFunc("/admin/accounts/{account}/keys", s.adminOnly(s.accountsEnabled(s.listKeysHandler))).Methods("GET")
+	r.HandleFunc("/admin/accounts/{account}/keys/{key}", s.adminOnly(s.accountsEnabled(s.deleteKeyHandler))).Methods("DELETE")
 
-	http.Handle("/", logH)
+	r.HandleFunc("/admin/snapshot", s.adminOnly(s.snapshotHandler)).Methods("GET")
+	r.HandleFunc("/admin/restore", s.adminOnly(s.restoreHandler)).Methods("POST")
+	r.HandleFunc("/admin/export", s.adminOnly(s.exportHandler)).Methods("GET")
+	r.HandleFunc("/admin/import", s.adminOnly(s.importHandler)).Methods("POST")
+	r.HandleFunc("/admin/audit", s.adminOnly(s.auditHandler)).Methods("GET")
+
+	// metrics move to the diagnostics listener once it's configured
+	if s.diagnosticsAddr == "" {
+		r.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	}
 
-	s.mux = r
+	s.mux = s.observe(r)
 }
@@ -5,12 +5,16 @@ import (
 	"os"
 	"testing"
 
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
 	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/store/boltdb"
 	"github.com/storageos/discovery/util/codecs"
 	"github.com/storageos/discovery/util/uuid"
 )
 
+const testAdminToken = "test-admin-token"
+
 type TestServer struct {
 	path   string
 	server *Server
@@ -28,9 +32,24 @@ func setupTestServer(t *testing.T) *TestServer {
 		t.Fatal(err)
 	}
 
-	cm := cluster.New(store, codecs.DefaultSerializer())
+	accountStore, err := store.Bucket("accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := account.New(accountStore, codecs.DefaultSerializer())
+
+	cm := cluster.New(store, codecs.DefaultSerializer(), cluster.WithQuotas(accounts))
+
+	auditStore, err := store.Bucket("audit")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	server := NewServer(1, cm)
+	server := NewServer(1, cm,
+		WithAdminToken(testAdminToken),
+		WithAuditLog(audit.New(auditStore, codecs.DefaultSerializer(), 0)),
+		WithAccounts(accounts),
+	)
 
 	return &TestServer{
 		path:   file.Name(),
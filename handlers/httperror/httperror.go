@@ -1,16 +1,50 @@
 package httperror
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
-	"strconv"
 
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/storageos/discovery/util/logging"
 )
 
-func Error(w http.ResponseWriter, r *http.Request, error string, code int, httpReqs *prometheus.CounterVec) {
+// StatusClientClosedRequest - non-standard status of requests the client
+// gave up on before they completed, as used by nginx
+const StatusClientClosedRequest = 499
+
+// Error - replies with the error message. Server errors are logged with the request's logger, client errors only at debug
+// level as the access log already records them.
+func Error(w http.ResponseWriter, r *http.Request, error string, code int) {
 	http.Error(w, error, code)
-	log.Println(fmt.Sprintf("%s: %s", http.StatusText(code), error))
-	httpReqs.WithLabelValues(strconv.Itoa(code), r.Method).Add(1)
+
+	level := slog.LevelDebug
+	if code >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	logging.FromContext(r.Context()).Log(r.Context(), level, statusText(code), "status", code, logging.FieldError, error)
+}
+
+// Internal - replies to unexpected errors, requests cancelled by the client
+// get 499 and timed out requests 503 instead of 500
+func Internal(w http.ResponseWriter, r *http.Request, err error) {
+	Error(w, r, err.Error(), Code(err))
+}
+
+// Code - status code of an unexpected error
+func Code(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func statusText(code int) string {
+	if code == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(code)
 }
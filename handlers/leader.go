@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/types"
+)
+
+func (s *Server) confirmLeaderHandler(w http.ResponseWriter, r *http.Request) {
+	s.leaderRequest(w, r, audit.ActionConfirm, s.clusterManager.ConfirmLeader)
+}
+
+func (s *Server) handoverLeaderHandler(w http.ResponseWriter, r *http.Request) {
+	s.leaderRequest(w, r, audit.ActionHandover, s.clusterManager.HandoverLeader)
+}
+
+func (s *Server) leaderRequest(w http.ResponseWriter, r *http.Request, action audit.Action, fn func(ctx context.Context, clusterID, nodeID string, epoch uint64) (before, updated *types.Cluster, err error)) {
+	clusterID := getParam(paramCluster, r)
+
+	var req types.LeaderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, updated, err := fn(r.Context(), clusterID, req.NodeID, req.Epoch)
+	if err != nil {
+		switch err {
+		case cluster.ErrNoLeader, cluster.ErrNotLeader, cluster.ErrStaleEpoch, cluster.ErrLeaderConfirmed,
+			cluster.ErrHandoverTooEarly, cluster.ErrNoLeaderCandidate:
+			httperror.Error(w, r, err.Error(), http.StatusConflict)
+		default:
+			s.nodeError(w, r, err)
+		}
+		return
+	}
+
+	if !leaderEqual(before.Leader, updated.Leader) {
+		s.record(r, action, clusterID, before, updated)
+	}
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+// leaderEqual - repeated confirmations don't change the leader
+func leaderEqual(a, b *types.Leader) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.NodeID == b.NodeID && a.Epoch == b.Epoch && (a.ConfirmedAt == nil) == (b.ConfirmedAt == nil)
+}
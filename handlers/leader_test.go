@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/types"
+)
+
+func TestLeaderHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*types.Node{
+		{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"},
+		{ID: "2", Name: "node2", AdvertiseAddress: "192.168.0.2"},
+	} {
+		if _, _, err := srv.server.clusterManager.RegisterNode(ctx, c.ID, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		action string
+		req    types.LeaderRequest
+		code   int
+		leader string
+	}{
+		{"confirm", types.LeaderRequest{NodeID: "2", Epoch: 1}, http.StatusConflict, ""},
+		{"handover", types.LeaderRequest{NodeID: "2", Epoch: 1}, http.StatusConflict, ""},
+		{"handover", types.LeaderRequest{NodeID: "3", Epoch: 1}, http.StatusNotFound, ""},
+		{"handover", types.LeaderRequest{NodeID: "1", Epoch: 1}, http.StatusOK, "2"},
+		{"confirm", types.LeaderRequest{NodeID: "2", Epoch: 1}, http.StatusConflict, ""},
+		{"confirm", types.LeaderRequest{NodeID: "2", Epoch: 2}, http.StatusOK, "2"},
+		{"confirm", types.LeaderRequest{NodeID: "2", Epoch: 2}, http.StatusOK, "2"},
+	}
+
+	for _, step := range steps {
+		body, err := json.Marshal(step.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(http.MethodPost, "/clusters/"+c.ID+"/leader/"+step.action, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+
+		if resp.Code != step.code {
+			t.Errorf("%s %+v: got code %d, wanted %d", step.action, step.req, resp.Code, step.code)
+			continue
+		}
+		if step.leader == "" {
+			continue
+		}
+
+		var updated types.Cluster
+		if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if updated.Leader == nil || updated.Leader.NodeID != step.leader {
+			t.Errorf("%s %+v: got leader %+v, wanted %s", step.action, step.req, updated.Leader, step.leader)
+		}
+	}
+
+	history, err := srv.server.auditLog.Query(ctx, audit.Query{ClusterID: c.ID})
+	if err != nil {
+		t.Fatalf("failed to query audit log: %v", err)
+	}
+	actions := map[audit.Action]int{}
+	for _, e := range history {
+		actions[e.Action]++
+	}
+	if actions[audit.ActionHandover] != 1 || actions[audit.ActionConfirm] != 1 {
+		t.Errorf("expected a single handover and confirmation in history, got: %v", actions)
+	}
+}
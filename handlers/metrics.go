@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
+)
+
+// WithRegistry - registers server metrics in the given registry instead of
+// a new one, e.g. to add collectors of an embedding program. The registry
+// can't be shared between servers as their metrics have the same names.
+func WithRegistry(reg *prometheus.Registry) Option {
+	return OptionFn(func(s *Server) error {
+		s.registry = reg
+		return nil
+	})
+}
+
+// newRegistry - registry with the process and Go runtime collectors the
+// default registry comes with
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewProcessCollector(os.Getpid(), ""),
+		prometheus.NewGoCollector(),
+	)
+	return reg
+}
+
+// metrics - collectors of a single server. Requests are counted by the
+// middleware, per route template.
+type metrics struct {
+	phaseTransitions *prometheus.CounterVec
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	stats *statsCollector
+}
+
+// newMetrics - creates collectors and registers them in reg
+func newMetrics(reg prometheus.Registerer, cm cluster.Manager, logger *slog.Logger) *metrics {
+	m := &metrics{
+		phaseTransitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cluster_phase_transitions_total",
+				Help: "How many times clusters changed phase, partitioned by previous and new phase.",
+			},
+			[]string{"from", "to"},
+		),
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_requests_total",
+				Help: "How many HTTP requests processed, partitioned by route template, HTTP method and status code.",
+			},
+			[]string{"route", "method", "code"},
+		),
+		duration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_request_duration_seconds",
+				Help:    "HTTP request latencies in seconds, partitioned by route template, HTTP method and status code.",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"route", "method", "code"},
+		),
+		stats: newStatsCollector(cm, logger),
+	}
+
+	reg.MustRegister(
+		m.phaseTransitions, m.requests, m.duration, m.stats,
+	)
+	return m
+}
+
+// observe - counts request and observes its latency
+func (m *metrics) observe(route, method string, code int, elapsed time.Duration) {
+	c := strconv.Itoa(code)
+	m.requests.WithLabelValues(route, method, c).Inc()
+	m.duration.WithLabelValues(route, method, c).Observe(elapsed.Seconds())
+}
+
+// statsCollector - reports cluster statistics of the manager at scrape time
+type statsCollector struct {
+	manager cluster.Manager
+	logger  *slog.Logger
+
+	clusters       *prometheus.Desc
+	clustersPhase  *prometheus.Desc
+	nodes          *prometheus.Desc
+	storeSize      *prometheus.Desc
+	reaperRuns     *prometheus.Desc
+	reapedClusters *prometheus.Desc
+}
+
+func newStatsCollector(cm cluster.Manager, logger *slog.Logger) *statsCollector {
+	return &statsCollector{
+		manager:        cm,
+		logger:         logger,
+		clusters:       prometheus.NewDesc("discovery_clusters", "Number of clusters, deleted clusters excluded.", nil, nil),
+		clustersPhase:  prometheus.NewDesc("discovery_clusters_by_phase", "Number of clusters, partitioned by phase.", []string{"phase"}, nil),
+		nodes:          prometheus.NewDesc("discovery_nodes", "Number of nodes registered in clusters.", nil, nil),
+		storeSize:      prometheus.NewDesc("discovery_store_size_bytes", "Size of the store in bytes.", nil, nil),
+		reaperRuns:     prometheus.NewDesc("discovery_reaper_runs_total", "How many times the TTL reaper ran.", nil, nil),
+		reapedClusters: prometheus.NewDesc("discovery_reaped_clusters_total", "How many deleted or expired clusters the TTL reaper purged.", nil, nil),
+	}
+}
+
+// Describe - implements prometheus.Collector
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.clusters
+	ch <- c.clustersPhase
+	ch <- c.nodes
+	ch <- c.storeSize
+	ch <- c.reaperRuns
+	ch <- c.reapedClusters
+}
+
+// Collect - implements prometheus.Collector
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	s, err := c.manager.Stats(context.Background())
+	if err != nil {
+		c.logger.Error("failed to collect cluster stats", logging.FieldError, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.clusters, prometheus.GaugeValue, float64(s.Clusters))
+	// report every phase so series don't disappear when no cluster is in it
+	for _, phase := range []types.ClusterPhase{
+		types.ClusterPhaseForming,
+		types.ClusterPhaseComplete,
+		types.ClusterPhaseActive,
+		types.ClusterPhaseDegraded,
+		types.ClusterPhaseExpired,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.clustersPhase, prometheus.GaugeValue, float64(s.ClustersByPhase[phase]), string(phase))
+	}
+	ch <- prometheus.MustNewConstMetric(c.nodes, prometheus.GaugeValue, float64(s.Nodes))
+	if s.StoreSize >= 0 {
+		ch <- prometheus.MustNewConstMetric(c.storeSize, prometheus.GaugeValue, float64(s.StoreSize))
+	}
+	ch <- prometheus.MustNewConstMetric(c.reaperRuns, prometheus.CounterValue, float64(s.ReaperRuns))
+	ch <- prometheus.MustNewConstMetric(c.reapedClusters, prometheus.CounterValue, float64(s.ReapedClusters))
+}
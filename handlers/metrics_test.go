@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/storageos/discovery/types"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = srv.server.clusterManager.RegisterNode(ctx, c.ID, &types.Node{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := request(http.MethodGet, "/clusters/"+c.ID); resp.Code != http.StatusOK {
+		t.Fatalf("unexpected cluster code %d: %s", resp.Code, resp.Body)
+	}
+	if resp := request(http.MethodGet, "/clusters/missing"); resp.Code != http.StatusNotFound {
+		t.Fatalf("unexpected missing cluster code %d: %s", resp.Code, resp.Body)
+	}
+	request(http.MethodGet, "/no-such-path")
+
+	resp := request(http.MethodGet, "/metrics")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected metrics code %d: %s", resp.Code, resp.Body)
+	}
+	body := resp.Body.String()
+
+	for _, series := range []string{
+		`http_requests_total{code="200",method="GET",route="/clusters/{ref}"}`,
+		`http_requests_total{code="404",method="GET",route="/clusters/{ref}"}`,
+		`http_requests_total{code="404",method="GET",route="unmatched"}`,
+		`http_request_duration_seconds_bucket{code="200",method="GET",route="/clusters/{ref}",le="+Inf"}`,
+		`http_request_duration_seconds_count{code="404",method="GET",route="/clusters/{ref}"}`,
+		"discovery_clusters 1",
+		`discovery_clusters_by_phase{phase="Forming"} 1`,
+		`discovery_clusters_by_phase{phase="Active"} 0`,
+		"discovery_nodes 1",
+		"discovery_store_size_bytes ",
+		"discovery_reaper_runs_total 0",
+		"discovery_reaped_clusters_total 0",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("expected series %s in metrics output", series)
+		}
+	}
+	if strings.Contains(body, "endpoint_") {
+		t.Errorf("expected requests to be counted only per route")
+	}
+	if strings.Contains(body, c.ID) {
+		t.Errorf("cluster ID leaked into metric labels")
+	}
+}
+
+func TestServerRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	first := setupTestServer(t)
+	defer teardownTestServer(t, first)
+	second := setupTestServer(t)
+	defer teardownTestServer(t, second)
+
+	if _, err := first.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func(srv *Server) string {
+		req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected metrics code %d: %s", rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	if body := scrape(first.server); !strings.Contains(body, "discovery_clusters 1") {
+		t.Errorf("expected first server to report its cluster")
+	}
+	if body := scrape(second.server); !strings.Contains(body, "discovery_clusters 0") {
+		t.Errorf("expected second server to report its own, empty store")
+	}
+
+	reg := prometheus.NewRegistry()
+	srv := NewServer(1, first.server.clusterManager, WithRegistry(reg))
+	if srv.registry != reg {
+		t.Fatalf("expected supplied registry to be used")
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %s", err)
+	}
+	found := false
+	for _, f := range families {
+		if f.GetName() == "discovery_clusters" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected server metrics in the supplied registry")
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/storageos/discovery/tracing"
+	"github.com/storageos/discovery/util/logging"
+	"github.com/storageos/discovery/util/uuid"
+)
+
+// routeUnmatched - route label of requests no route matched
+const routeUnmatched = "unmatched"
+
+// statusWriter - remembers the status code and size of the response
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// observe - propagates the request ID supplied by the client or an ingress,
+// generating one otherwise, and hands handlers a logger carrying it together
+// with the cluster and node IDs of the route. Requests are counted, timed
+// and logged once they complete, labelled by the route template so cluster
+// IDs don't end up in metric labels. Every request gets a server span
+// continuing the trace of the caller's traceparent header. Handlers get a
+// context that is cancelled when the client goes away or the request
+// timeout passes.
+func (s *Server) observe(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerRequestID)
+		if id == "" {
+			id = uuid.Generate()
+			r.Header.Set(headerRequestID, id)
+		}
+		w.Header().Set(headerRequestID, id)
+
+		route := routeUnmatched
+		logger := s.logger.With(logging.FieldRequestID, id)
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+			if clusterID := match.Vars[paramCluster]; clusterID != "" {
+				logger = logger.With(logging.FieldClusterID, clusterID)
+			}
+			if nodeID := match.Vars[paramNode]; nodeID != "" {
+				logger = logger.With(logging.FieldNodeID, nodeID)
+			}
+		}
+
+		remote, _ := tracing.Extract(r.Header)
+		ctx, span := s.tracer.StartRoot(r.Context(), r.Method+" "+route, tracing.SpanKindServer, remote,
+			tracing.String("http.method", r.Method),
+			tracing.String("http.route", route),
+			tracing.String("http.target", r.URL.Path),
+		)
+		if sc := span.SpanContext(); sc.IsValid() {
+			logger = logger.With(logging.FieldTraceID, sc.TraceID.String())
+		}
+		if s.requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+			defer cancel()
+		}
+		r = r.WithContext(logging.WithContext(ctx, logger))
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		router.ServeHTTP(sw, r)
+		elapsed := time.Since(start)
+
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		s.metrics.observe(route, r.Method, sw.code, elapsed)
+
+		span.SetAttributes(tracing.Int("http.status_code", sw.code))
+		if sw.code >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("status code %d", sw.code))
+		}
+		span.End()
+
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.code),
+			slog.Int("bytes", sw.bytes),
+			slog.Duration("duration", elapsed),
+			slog.String("remote", r.RemoteAddr),
+		)
+	})
+}
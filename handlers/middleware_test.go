@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/tracing"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+func TestRequestLogging(t *testing.T) {
+	ctx := context.Background()
+
+	ts := setupTestServer(t)
+	defer teardownTestServer(t, ts)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	srv := NewServer(1, ts.server.clusterManager, WithLogger(logger), WithRegistry(prometheus.NewRegistry()))
+
+	c, err := srv.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(path, requestID string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodPost, path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if requestID != "" {
+			req.Header.Set(headerRequestID, requestID)
+		}
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	resp := request("/clusters/"+c.ID+"/nodes/missing/heartbeat", "req-1")
+	if resp.Code != http.StatusNotFound {
+		t.Fatalf("unexpected heartbeat code %d: %s", resp.Code, resp.Body)
+	}
+	if id := resp.Header().Get(headerRequestID); id != "req-1" {
+		t.Errorf("expected request ID to be propagated, got %q", id)
+	}
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode log line %q: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected error and access log entries, got %d: %s", len(entries), buf.String())
+	}
+	for _, entry := range entries {
+		if entry["request_id"] != "req-1" || entry["cluster_id"] != c.ID || entry["node_id"] != "missing" {
+			t.Errorf("expected request, cluster and node IDs in log entry: %v", entry)
+		}
+	}
+	access := entries[1]
+	if access["msg"] != "request" || access["route"] != "/clusters/{ref}/nodes/{node}/heartbeat" || access["status"] != float64(http.StatusNotFound) {
+		t.Errorf("unexpected access log entry: %v", access)
+	}
+
+	buf.Reset()
+	resp = request("/clusters/"+c.ID+"/nodes/missing/heartbeat", "")
+	if resp.Header().Get(headerRequestID) == "" {
+		t.Errorf("expected request ID to be generated")
+	}
+	if !strings.Contains(buf.String(), resp.Header().Get(headerRequestID)) {
+		t.Errorf("expected generated request ID in logs: %s", buf.String())
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	ctx := context.Background()
+
+	ts := setupTestServer(t)
+	defer teardownTestServer(t, ts)
+
+	var buf bytes.Buffer
+	tracer := tracing.New(tracing.NewStdoutExporter(&buf))
+	defer tracer.Stop()
+
+	cm := cluster.New(store.Traced(ts.store, "clusters"), codecs.DefaultSerializer())
+	srv := NewServer(1, cluster.Traced(cm), WithTracer(tracer), WithRegistry(prometheus.NewRegistry()))
+
+	c, err := srv.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(types.Node{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, "/clusters/"+c.ID, bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req.Header.Set(tracing.HeaderTraceparent, traceparent)
+
+	rec := httptest.NewRecorder()
+	srv.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected register code %d: %s", rec.Code, rec.Body)
+	}
+	tracer.Flush()
+
+	type span struct {
+		Name     string `json:"name"`
+		TraceID  string `json:"traceID"`
+		SpanID   string `json:"spanID"`
+		ParentID string `json:"parentID"`
+	}
+	spans := map[string]span{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var s span
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			t.Fatalf("failed to decode span %q: %s", line, err)
+		}
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected span %s to continue the caller's trace, got trace %s", s.Name, s.TraceID)
+		}
+		spans[s.Name] = s
+	}
+
+	server, ok := spans["PUT /clusters/{ref}"]
+	if !ok {
+		t.Fatalf("server span missing: %v", spans)
+	}
+	if server.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("expected server span to be a child of the caller's span, got parent %q", server.ParentID)
+	}
+
+	// manager, store and codec spans nest within the request
+	for name, parent := range map[string]string{
+		"cluster.RegisterNode": server.SpanID,
+		"store.Update":         spans["cluster.RegisterNode"].SpanID,
+		"codec.Decode":         spans["cluster.RegisterNode"].SpanID,
+		"codec.Encode":         spans["cluster.RegisterNode"].SpanID,
+	} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("%s span missing", name)
+			continue
+		}
+		if s.ParentID != parent {
+			t.Errorf("expected %s span parent %s, got %s", name, parent, s.ParentID)
+		}
+	}
+}
+
+func TestRequestCancellation(t *testing.T) {
+	ctx := context.Background()
+
+	ts := setupTestServer(t)
+	defer teardownTestServer(t, ts)
+
+	c, err := ts.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(srv *Server, ctx context.Context) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/clusters/"+c.ID, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.mux.ServeHTTP(rec, req.WithContext(ctx))
+		return rec
+	}
+
+	// client went away
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if resp := request(ts.server, cancelled); resp.Code != httperror.StatusClientClosedRequest {
+		t.Errorf("expected cancelled request to get %d, got %d: %s", httperror.StatusClientClosedRequest, resp.Code, resp.Body)
+	}
+
+	// request timeout passed
+	srv := NewServer(1, ts.server.clusterManager, WithRequestTimeout(time.Nanosecond), WithRegistry(prometheus.NewRegistry()))
+	if resp := request(srv, ctx); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected timed out request to get %d, got %d: %s", http.StatusServiceUnavailable, resp.Code, resp.Body)
+	}
+
+	if resp := request(ts.server, ctx); resp.Code != http.StatusOK {
+		t.Errorf("unexpected code %d: %s", resp.Code, resp.Body)
+	}
+}
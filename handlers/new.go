@@ -1,61 +1,88 @@
 package handlers
 
 import (
-	"log"
+	"errors"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"encoding/json"
 
-	"github.com/storageos/discovery/types"
-
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/handlers/httperror"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/logging"
 )
 
-var newCounter *prometheus.CounterVec
-
 // var cfg *client.Config
 var discHost string
 
-func init() {
-	newCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "endpoint_new_requests_total",
-			Help: "How many /new requests processed, partitioned by status code and HTTP method.",
-		},
-		[]string{"code", "method"},
-	)
-	prometheus.MustRegister(newCounter)
+// createRequest - optional JSON body of cluster creation requests
+type createRequest struct {
+	Reservations []types.Reservation `json:"reservations"`
 }
 
+// newClusterHandler - clusters created with an account's API key belong to
+// the account, the admin can create clusters for any account
 func (s *Server) newClusterHandler(w http.ResponseWriter, r *http.Request) {
-	var err error
+	accountID, keyID, err := s.credentials(r)
+	if err != nil {
+		if err == errInvalidCredentials {
+			httperror.Error(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+	if keyID == keyIDAdmin {
+		accountID = r.FormValue("account")
+	}
+	if keyID != "" {
+		r = withKeyID(r, keyID)
+	}
+
 	size := 3
 	sz := r.FormValue("size")
 	if sz != "" {
 		size, err = strconv.Atoi(sz)
 		if err != nil {
-			httperror.Error(w, r, err.Error(), http.StatusBadRequest, newCounter)
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 	name := r.FormValue("name")
 
-	cluster, err := s.clusterManager.Create(types.ClusterCreateOps{Size: size, Name: name})
+	// reserved slots are passed in a JSON body
+	var body createRequest
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			httperror.Error(w, r, "invalid cluster request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	created, err := s.clusterManager.Create(r.Context(), types.ClusterCreateOps{Size: size, Name: name, AccountID: accountID, Reservations: body.Reservations})
 	if err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		switch {
+		case errors.Is(err, cluster.ErrQuotaExceeded):
+			httperror.Error(w, r, err.Error(), http.StatusForbidden)
+		case errors.Is(err, cluster.ErrUnknownAccount), errors.Is(err, cluster.ErrInvalidReservation):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		default:
+			httperror.Internal(w, r, err)
+		}
 		return
 	}
 
-	log.Println("New cluster created", cluster.ID)
+	logging.FromContext(r.Context()).Info("new cluster created", logging.FieldClusterID, created.ID, "size", created.Size)
+	s.record(r, audit.ActionCreate, created.ID, nil, created)
 
-	bts, err := json.Marshal(cluster)
+	bts, err := json.Marshal(created)
 	if err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	w.Write(bts)
-	newCounter.WithLabelValues(strconv.Itoa(http.StatusCreated), r.Method).Add(1)
 }
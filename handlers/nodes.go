@@ -2,54 +2,55 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
+	"github.com/storageos/discovery/audit"
 	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/handlers/httperror"
 	"github.com/storageos/discovery/store"
 	"github.com/storageos/discovery/types"
-
-	"github.com/prometheus/client_golang/prometheus"
-)
-
-func init() {
-	tokenCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "endpoint_cluster_requests_total",
-			Help: "How many /cluster requests processed, partitioned by status code and HTTP method.",
-		},
-		[]string{"code", "method"},
-	)
-	prometheus.MustRegister(tokenCounter)
-}
-
-var (
-	tokenCounter *prometheus.CounterVec
 )
 
 func (s *Server) clusterHandler(w http.ResponseWriter, r *http.Request) {
 
-	cluster, err := s.clusterManager.Get(getParam(paramCluster, r))
+	filter := cluster.NodeFilter{Role: r.FormValue("role")}
+	for _, selector := range r.Form["label"] {
+		if err := filter.ParseLabelSelector(selector); err != nil {
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	c, err := s.clusterManager.Get(r.Context(), getParam(paramCluster, r))
 	if err != nil {
 		if err == store.ErrNotFound {
-			httperror.Error(w, r, err.Error(), http.StatusNotFound, newCounter)
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
 			return
 		}
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 
-	bts, err := json.Marshal(cluster)
+	setValidators(w, c)
+	if notModified(r, c) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if filter.Role != "" || len(filter.Labels) > 0 {
+		c.Nodes = cluster.FilterNodes(c.Nodes, filter)
+	}
+
+	bts, err := json.Marshal(cluster.WithoutTokens(c))
 	if err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(bts)
-	tokenCounter.WithLabelValues(strconv.Itoa(http.StatusOK), r.Method).Add(1)
 }
 
 func (s *Server) registerNodeHandler(w http.ResponseWriter, r *http.Request) {
@@ -57,49 +58,281 @@ func (s *Server) registerNodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	var node types.Node
 	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 
-	updated, err := s.clusterManager.RegisterNode(clusterID, &node)
+	before, updated, err := s.clusterManager.RegisterNode(r.Context(), clusterID, &node)
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			httperror.Error(w, r, err.Error(), http.StatusNotFound, newCounter)
+		switch {
+		case err == store.ErrNotFound:
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		case err == cluster.ErrClusterExpired:
+			httperror.Error(w, r, err.Error(), http.StatusGone)
+			return
+		case cluster.IsValidationError(err):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		case err == cluster.ErrNodeNamePresent:
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(": name %s exists in cluster %s", node.Name, clusterID), http.StatusUnprocessableEntity)
 			return
-		case cluster.ErrAddressMissing, cluster.ErrInvalidAddress, cluster.ErrNameMissing:
-			httperror.Error(w, r, err.Error(), http.StatusBadRequest, newCounter)
+		case err == cluster.ErrNodeAddressPresent:
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(": address %s exists in cluster %s", node.AdvertiseAddress, clusterID), http.StatusUnprocessableEntity)
 			return
-		case cluster.ErrNodeNamePresent:
-			httperror.Error(w, r, err.Error()+fmt.Sprintf(": name %s exists in cluster %s", node.Name, clusterID), http.StatusUnprocessableEntity, newCounter)
+		case errors.Is(err, cluster.ErrNodeEndpointPresent):
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(" exists in cluster %s", clusterID), http.StatusUnprocessableEntity)
 			return
-		case cluster.ErrNodeAddressPresent:
-			httperror.Error(w, r, err.Error()+fmt.Sprintf(": address %s exists in cluster %s", node.AdvertiseAddress, clusterID), http.StatusUnprocessableEntity, newCounter)
+		case errors.Is(err, cluster.ErrSlotReserved), err == cluster.ErrInvalidSlotToken:
+			httperror.Error(w, r, err.Error(), http.StatusForbidden)
+			return
+		case err == cluster.ErrNoFreeSlot:
+			httperror.Error(w, r, err.Error(), http.StatusConflict)
 			return
 		}
 
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 
-	bts, err := json.Marshal(updated)
+	if len(before.Nodes) != len(updated.Nodes) {
+		s.record(r, audit.ActionRegister, clusterID, before, updated)
+	}
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
 	if err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		httperror.Internal(w, r, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(bts)
-	newCounter.WithLabelValues("200", r.Method).Add(1)
 
 }
 
+// registerNodesHandler - registers a JSON array of nodes, either all of
+// them or none. Rejected batches are answered with the per-node results
+// too, so callers can tell which nodes to fix.
+func (s *Server) registerNodesHandler(w http.ResponseWriter, r *http.Request) {
+	clusterID := getParam(paramCluster, r)
+
+	var nodes []*types.Node
+	if err := json.NewDecoder(r.Body).Decode(&nodes); err != nil {
+		httperror.Error(w, r, "invalid node batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, updated, results, err := s.clusterManager.RegisterNodes(r.Context(), clusterID, nodes)
+	code := http.StatusOK
+	if err != nil {
+		switch {
+		case err == store.ErrNotFound:
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		case err == cluster.ErrClusterExpired:
+			httperror.Error(w, r, err.Error(), http.StatusGone)
+			return
+		case err == cluster.ErrBatchEmpty:
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		case !errors.Is(err, cluster.ErrBatchRejected):
+			httperror.Internal(w, r, err)
+			return
+		}
+
+		// slot errors are answered like single registrations
+		switch {
+		case errors.Is(err, cluster.ErrSlotReserved), errors.Is(err, cluster.ErrInvalidSlotToken):
+			code = http.StatusForbidden
+		case errors.Is(err, cluster.ErrNoFreeSlot):
+			code = http.StatusConflict
+		default:
+			code = http.StatusUnprocessableEntity
+		}
+	}
+
+	if updated != nil && len(before.Nodes) != len(updated.Nodes) {
+		s.record(r, audit.ActionRegister, clusterID, before, updated)
+	}
+
+	bts, err := json.Marshal(&types.NodeBatchResult{Cluster: cluster.WithoutTokens(updated), Results: results})
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(bts)
+}
+
 func (s *Server) deleteClusterHandler(w http.ResponseWriter, r *http.Request) {
-	err := s.clusterManager.Delete(getParam(paramCluster, r))
+	clusterID := getParam(paramCluster, r)
+	current, _ := s.clusterManager.Get(r.Context(), clusterID)
+	r, ok := s.clusterOwner(w, r, current)
+	if !ok {
+		return
+	}
+
+	before, err := s.clusterManager.Delete(r.Context(), clusterID)
+	if err != nil {
+		if err == store.ErrNotFound {
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	s.record(r, audit.ActionDelete, clusterID, before, nil)
+
+}
+
+func (s *Server) undeleteClusterHandler(w http.ResponseWriter, r *http.Request) {
+	clusterID := getParam(paramCluster, r)
+	current, _ := s.clusterManager.GetDeleted(r.Context(), clusterID)
+	r, ok := s.clusterOwner(w, r, current)
+	if !ok {
+		return
+	}
+
+	restored, err := s.clusterManager.Undelete(r.Context(), clusterID)
+	if err != nil {
+		switch {
+		case err == store.ErrNotFound:
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		case err == cluster.ErrClusterNotDeleted:
+			httperror.Error(w, r, err.Error(), http.StatusConflict)
+			return
+		case err == cluster.ErrClusterExpired:
+			httperror.Error(w, r, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, cluster.ErrQuotaExceeded):
+			httperror.Error(w, r, err.Error(), http.StatusForbidden)
+			return
+		case errors.Is(err, cluster.ErrUnknownAccount):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	s.record(r, audit.ActionRestore, clusterID, nil, restored)
+
+	bts, err := json.Marshal(cluster.WithoutTokens(restored))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+func (s *Server) updateNodeHandler(w http.ResponseWriter, r *http.Request) {
+	clusterID := getParam(paramCluster, r)
+	nodeID := getParam(paramNode, r)
+
+	var update types.NodeUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	before, updated, err := s.clusterManager.UpdateNode(r.Context(), clusterID, nodeID, &update)
 	if err != nil {
-		httperror.Error(w, r, err.Error(), http.StatusInternalServerError, newCounter)
+		switch {
+		case err == store.ErrNotFound, err == cluster.ErrNodeNotFound:
+			httperror.Error(w, r, err.Error(), http.StatusNotFound)
+			return
+		case err == cluster.ErrClusterExpired:
+			httperror.Error(w, r, err.Error(), http.StatusGone)
+			return
+		case cluster.IsValidationError(err):
+			httperror.Error(w, r, err.Error(), http.StatusBadRequest)
+			return
+		case err == cluster.ErrNodeNamePresent && update.Name != nil:
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(": name %s exists in cluster %s", *update.Name, clusterID), http.StatusUnprocessableEntity)
+			return
+		case err == cluster.ErrNodeAddressPresent && update.AdvertiseAddress != nil:
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(": address %s exists in cluster %s", *update.AdvertiseAddress, clusterID), http.StatusUnprocessableEntity)
+			return
+		case err == cluster.ErrNodeNamePresent, err == cluster.ErrNodeAddressPresent:
+			// conflict isn't caused by a value of the update
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(" in cluster %s", clusterID), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, cluster.ErrNodeEndpointPresent):
+			httperror.Error(w, r, err.Error()+fmt.Sprintf(" exists in cluster %s", clusterID), http.StatusUnprocessableEntity)
+			return
+		case errors.Is(err, cluster.ErrSlotReserved):
+			httperror.Error(w, r, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		httperror.Internal(w, r, err)
 		return
 	}
 
-	newCounter.WithLabelValues("200", r.Method).Add(1)
+	s.record(r, audit.ActionUpdateNode, clusterID, before, updated)
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+func (s *Server) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	updated, err := s.clusterManager.Heartbeat(r.Context(), getParam(paramCluster, r), getParam(paramNode, r))
+	if err != nil {
+		s.nodeError(w, r, err)
+		return
+	}
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+func (s *Server) deregisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	clusterID := getParam(paramCluster, r)
+
+	before, updated, err := s.clusterManager.DeregisterNode(r.Context(), clusterID, getParam(paramNode, r))
+	if err != nil {
+		s.nodeError(w, r, err)
+		return
+	}
+
+	s.record(r, audit.ActionDeregister, clusterID, before, updated)
+
+	bts, err := json.Marshal(cluster.WithoutTokens(updated))
+	if err != nil {
+		httperror.Internal(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+// nodeError - responds to errors of requests addressing a single node
+func (s *Server) nodeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case store.ErrNotFound, cluster.ErrNodeNotFound:
+		httperror.Error(w, r, err.Error(), http.StatusNotFound)
+	case cluster.ErrClusterExpired:
+		httperror.Error(w, r, err.Error(), http.StatusGone)
+	default:
+		httperror.Internal(w, r, err)
+	}
 }
@@ -2,28 +2,33 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/storageos/discovery/audit"
 	"github.com/storageos/discovery/cluster"
 	"github.com/storageos/discovery/store"
 	"github.com/storageos/discovery/types"
 )
 
 func TestRegisterNodeHandler(t *testing.T) {
+	ctx := context.Background()
+
 	srv := setupTestServer(t)
 	defer teardownTestServer(t, srv)
 	// Create cluster as prerequisite
-	c, err := srv.server.clusterManager.Create(types.ClusterCreateOps{Size: 3})
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
 	if err != nil {
 		t.Error(err)
 	}
 	// Pre-place cluster for name/address conflict
-	srv.server.clusterManager.RegisterNode(
+	srv.server.clusterManager.RegisterNode(ctx,
 		c.ID,
 		&types.Node{ID: "4", Name: "node4", AdvertiseAddress: "192.168.0.4", CreatedAt: time.Now(), UpdatedAt: time.Now()},
 	)
@@ -95,9 +100,16 @@ func TestRegisterNodeHandler(t *testing.T) {
 		{
 			name:      "invalid address",
 			clusterID: c.ID,
-			node:      types.Node{ID: "5", Name: "node5", AdvertiseAddress: "192.168.0.5:5555", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			node:      types.Node{ID: "5", Name: "node5", AdvertiseAddress: "192.168.0.5:99999", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			code:      http.StatusBadRequest,
+			errMsg:    fmt.Sprintf("%s \"192.168.0.5:99999\": invalid port 99999\n", cluster.ErrInvalidAddress),
+		},
+		{
+			name:      "loopback address",
+			clusterID: c.ID,
+			node:      types.Node{ID: "5", Name: "node5", AdvertiseAddress: "http://127.0.0.1:5705", CreatedAt: time.Now(), UpdatedAt: time.Now()},
 			code:      http.StatusBadRequest,
-			errMsg:    fmt.Sprintf("%s\n", cluster.ErrInvalidAddress),
+			errMsg:    fmt.Sprintf("%s \"http://127.0.0.1:5705\": loopback and unspecified addresses are not allowed\n", cluster.ErrInvalidAddress),
 		},
 		{
 			name:      "non-existent cluster",
@@ -146,3 +158,627 @@ func TestRegisterNodeHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestRegisterNodesHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		path     string
+		body     string
+		code     int
+		statuses []string
+	}{
+		{
+			name:     "clashing nodes",
+			path:     "/clusters/" + c.ID + "/nodes:batch",
+			body:     `[{"id":"1","name":"node1","advertiseAddress":"192.168.0.1"},{"id":"2","name":"node1","advertiseAddress":"192.168.0.1"}]`,
+			code:     http.StatusUnprocessableEntity,
+			statuses: []string{types.NodeResultAborted, types.NodeResultRejected},
+		},
+		{
+			name:     "all nodes",
+			path:     "/clusters/" + c.ID + "/nodes:batch",
+			body:     `[{"id":"1","name":"node1","advertiseAddress":"192.168.0.1"},{"id":"2","name":"node2","advertiseAddress":"192.168.0.2"},{"id":"3","name":"node3","advertiseAddress":"192.168.0.3"}]`,
+			code:     http.StatusOK,
+			statuses: []string{types.NodeResultRegistered, types.NodeResultRegistered, types.NodeResultRegistered},
+		},
+		{name: "empty batch", path: "/clusters/" + c.ID + "/nodes:batch", body: `[]`, code: http.StatusBadRequest},
+		{name: "invalid body", path: "/clusters/" + c.ID + "/nodes:batch", body: `{"id":"1"}`, code: http.StatusBadRequest},
+		{name: "unknown cluster", path: "/clusters/unknown/nodes:batch", body: `[{"id":"1","name":"node1","advertiseAddress":"192.168.0.1"}]`, code: http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		req, err := http.NewRequest(http.MethodPost, step.path, bytes.NewBufferString(step.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+
+		if resp.Code != step.code {
+			t.Errorf("%s: got code %d, wanted %d: %s", step.name, resp.Code, step.code, resp.Body)
+			continue
+		}
+		if step.statuses == nil {
+			continue
+		}
+
+		var result types.NodeBatchResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", step.name, err)
+		}
+		if len(result.Results) != len(step.statuses) {
+			t.Fatalf("%s: expected %d results, got %+v", step.name, len(step.statuses), result.Results)
+		}
+		for i, r := range result.Results {
+			if r.Status != step.statuses[i] {
+				t.Errorf("%s: node %d: got status %s, wanted %s", step.name, i, r.Status, step.statuses[i])
+			}
+		}
+		if (result.Cluster != nil) != (step.code == http.StatusOK) {
+			t.Errorf("%s: expected cluster only for applied batches", step.name)
+		}
+	}
+
+	updated, err := srv.server.clusterManager.Get(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Nodes) != 3 || updated.Phase != types.ClusterPhaseComplete {
+		t.Errorf("expected complete cluster with 3 nodes, got %d nodes in phase %s", len(updated.Nodes), updated.Phase)
+	}
+
+	registrations, err := srv.server.auditLog.Query(ctx, audit.Query{ClusterID: c.ID, Action: audit.ActionRegister})
+	if err != nil {
+		t.Fatalf("failed to query audit log: %v", err)
+	}
+	if len(registrations) != 1 {
+		t.Errorf("expected batch to be audited once, got %d records", len(registrations))
+	}
+}
+
+func TestReservedSlots(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	resp := serve(http.MethodPost, "/clusters?size=2", `{"reservations":[{"name":"node1"},{"name":"node1"}]}`)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid reservations to be rejected, got %d", resp.Code)
+	}
+
+	resp = serve(http.MethodPost, "/clusters?size=2", `{"reservations":[{"name":"node1","advertiseAddress":"192.168.0.1"},{"requireToken":true}]}`)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("failed to create cluster: %d: %s", resp.Code, resp.Body)
+	}
+	var c types.Cluster
+	if err := json.Unmarshal(resp.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	token := c.Reservations[1].Token
+	if token == "" {
+		t.Fatalf("expected slot token in the create response")
+	}
+	if strings.Contains(resp.Body.String(), "tokenHash") {
+		t.Errorf("expected token hash not to be returned")
+	}
+
+	steps := []struct {
+		body string
+		code int
+	}{
+		{`{"id":"9","name":"node1","advertiseAddress":"192.168.0.9"}`, http.StatusForbidden},
+		{`{"id":"9","name":"node9","advertiseAddress":"192.168.0.9","slotToken":"wrong"}`, http.StatusForbidden},
+		{`{"id":"9","name":"node9","advertiseAddress":"192.168.0.9"}`, http.StatusConflict},
+		{`{"id":"1","name":"node1","advertiseAddress":"192.168.0.1"}`, http.StatusOK},
+		{`{"id":"2","name":"node2","advertiseAddress":"192.168.0.2","slotToken":"` + token + `"}`, http.StatusOK},
+	}
+	for _, step := range steps {
+		resp := serve(http.MethodPut, "/clusters/"+c.ID, step.body)
+		if resp.Code != step.code {
+			t.Errorf("%s: got code %d, wanted %d: %s", step.body, resp.Code, step.code, resp.Body)
+		}
+		if strings.Contains(resp.Body.String(), token) {
+			t.Errorf("%s: slot token leaked in response", step.body)
+		}
+	}
+
+	resp = serve(http.MethodGet, "/clusters/"+c.ID, "")
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), "tokenHash") {
+		t.Errorf("expected cluster without token hashes, got %d: %s", resp.Code, resp.Body)
+	}
+
+	// batches are answered like single registrations
+	batches := []struct {
+		body string
+		code int
+	}{
+		{`[{"id":"9","name":"node9","advertiseAddress":"192.168.0.9","slotToken":"` + token + `"}]`, http.StatusForbidden},
+		{`[{"id":"9","name":"node9","advertiseAddress":"192.168.0.9"}]`, http.StatusConflict},
+	}
+	for _, batch := range batches {
+		resp := serve(http.MethodPost, "/clusters/"+c.ID+"/nodes:batch", batch.body)
+		if resp.Code != batch.code {
+			t.Errorf("%s: got code %d, wanted %d: %s", batch.body, resp.Code, batch.code, resp.Body)
+		}
+	}
+
+	history, err := srv.server.auditLog.History(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts, err := json.Marshal(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bts), token) {
+		t.Errorf("expected slot token not to be recorded in the audit log")
+	}
+}
+
+func TestClusterHistoryHandler(t *testing.T) {
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBuffer(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("X-Request-ID", "test-request")
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	resp := serve(http.MethodPost, "/clusters", nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("failed to create cluster: %d", resp.Code)
+	}
+	var c types.Cluster
+	if err := json.Unmarshal(resp.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	serve(http.MethodPut, "/clusters/"+c.ID, []byte(`{"id":"1","name":"node1","advertiseAddress":"192.168.0.1"}`))
+	serve(http.MethodDelete, "/clusters/"+c.ID, nil)
+
+	resp = serve(http.MethodGet, "/clusters/"+c.ID+"/history", nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("unexpected history code %d", resp.Code)
+	}
+
+	var entries []audit.Entry
+	if err := json.Unmarshal(resp.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []audit.Action{audit.ActionCreate, audit.ActionRegister, audit.ActionDelete}
+	if len(entries) != len(want) {
+		t.Fatalf("expected %d history entries, got %d", len(want), len(entries))
+	}
+	for i, e := range entries {
+		if e.Action != want[i] {
+			t.Errorf("entry %d: expected action %s, got %s", i, want[i], e.Action)
+		}
+		if e.RequestID != "test-request" {
+			t.Errorf("entry %d: unexpected request ID %q", i, e.RequestID)
+		}
+		if e.Actor != (audit.Actor{}) {
+			t.Errorf("entry %d: expected actor of an open cluster to be left out, got %+v", i, e.Actor)
+		}
+	}
+	if entries[2].Before == nil || len(entries[2].Before.Nodes) != 1 {
+		t.Errorf("expected delete entry to carry the deleted cluster")
+	}
+}
+
+func TestDeleteRestoreClusterHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	steps := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodDelete, "/clusters/unknown", http.StatusNotFound},
+		{http.MethodDelete, "/clusters/" + c.ID, http.StatusOK},
+		{http.MethodGet, "/clusters/" + c.ID, http.StatusNotFound},
+		{http.MethodDelete, "/clusters/" + c.ID, http.StatusNotFound},
+		{http.MethodPost, "/clusters/" + c.ID + "/restore", http.StatusOK},
+		{http.MethodGet, "/clusters/" + c.ID, http.StatusOK},
+		{http.MethodPost, "/clusters/" + c.ID + "/restore", http.StatusConflict},
+		{http.MethodPost, "/clusters/unknown/restore", http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		resp := serve(step.method, step.path)
+		if resp.Code != step.code {
+			t.Errorf("%s %s: got code %d, wanted %d", step.method, step.path, resp.Code, step.code)
+		}
+	}
+}
+
+func TestClusterHandlerNodeFilter(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := []*types.Node{
+		{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1", Role: types.NodeRoleController, Zone: "a"},
+		{ID: "2", Name: "node2", AdvertiseAddress: "192.168.0.2", Role: types.NodeRoleController, Zone: "b", Labels: map[string]string{"disk": "ssd"}},
+		{ID: "3", Name: "node3", AdvertiseAddress: "192.168.0.3", Role: types.NodeRoleWitness, Zone: "a", Labels: map[string]string{"disk": "ssd"}},
+	}
+	for _, n := range nodes {
+		if _, _, err := srv.server.clusterManager.RegisterNode(ctx, c.ID, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		query string
+		code  int
+		names []string
+	}{
+		{"", http.StatusOK, []string{"node1", "node2", "node3"}},
+		{"?role=controller", http.StatusOK, []string{"node1", "node2"}},
+		{"?label=zone=a", http.StatusOK, []string{"node1", "node3"}},
+		{"?role=controller&label=zone=a", http.StatusOK, []string{"node1"}},
+		{"?label=disk=ssd&label=zone=a", http.StatusOK, []string{"node3"}},
+		{"?role=storage", http.StatusOK, []string{}},
+		{"?label==a", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range testcases {
+		req, err := http.NewRequest(http.MethodGet, "/clusters/"+c.ID+tc.query, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Errorf("%s: got code %d, wanted %d", tc.query, rec.Code, tc.code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+
+		var got types.Cluster
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Nodes) != len(tc.names) {
+			t.Errorf("%s: got %d nodes, wanted %d", tc.query, len(got.Nodes), len(tc.names))
+			continue
+		}
+		for i, n := range got.Nodes {
+			if n.Name != tc.names[i] {
+				t.Errorf("%s: node %d is %s, wanted %s", tc.query, i, n.Name, tc.names[i])
+			}
+		}
+	}
+}
+
+func TestUpdateNodeHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		_, _, err := srv.server.clusterManager.RegisterNode(ctx, c.ID, &types.Node{
+			ID:               fmt.Sprintf("%d", i),
+			Name:             fmt.Sprintf("node%d", i),
+			AdvertiseAddress: fmt.Sprintf("192.168.0.%d", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testcases := []struct {
+		name   string
+		path   string
+		body   string
+		code   int
+		errMsg string
+	}{
+		{
+			name: "new address",
+			path: "/clusters/" + c.ID + "/nodes/1",
+			body: `{"advertiseAddress":"192.168.1.1","labels":{"zone":"a"}}`,
+			code: http.StatusOK,
+		},
+		{
+			name:   "address of another node",
+			path:   "/clusters/" + c.ID + "/nodes/1",
+			body:   `{"advertiseAddress":"192.168.0.2"}`,
+			code:   http.StatusUnprocessableEntity,
+			errMsg: fmt.Sprintf("%s: address 192.168.0.2 exists in cluster %s\n", cluster.ErrNodeAddressPresent, c.ID),
+		},
+		{
+			name: "invalid role",
+			path: "/clusters/" + c.ID + "/nodes/1",
+			body: `{"role":"leader"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown node",
+			path:   "/clusters/" + c.ID + "/nodes/9",
+			body:   `{"name":"node9"}`,
+			code:   http.StatusNotFound,
+			errMsg: fmt.Sprintf("%s\n", cluster.ErrNodeNotFound),
+		},
+		{
+			name: "unknown cluster",
+			path: "/clusters/123/nodes/1",
+			body: `{"name":"node9"}`,
+			code: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testcases {
+		req, err := http.NewRequest(http.MethodPatch, tc.path, bytes.NewBufferString(tc.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(rec, req)
+
+		if rec.Code != tc.code {
+			t.Errorf("%s: got code %d, wanted %d (%s)", tc.name, rec.Code, tc.code, rec.Body)
+		}
+		if tc.errMsg != "" && rec.Body.String() != tc.errMsg {
+			t.Errorf("%s: got err %q, wanted %q", tc.name, rec.Body.String(), tc.errMsg)
+		}
+	}
+
+	history, err := srv.server.auditLog.History(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := history[len(history)-1]
+	if last.Action != audit.ActionUpdateNode {
+		t.Fatalf("expected node update in history, got %s", last.Action)
+	}
+	changed := map[string]bool{}
+	for _, change := range last.Changes {
+		changed[change.Field] = true
+	}
+	if !changed["nodes[1].advertiseAddress"] || !changed["nodes[1].labels"] {
+		t.Errorf("unexpected changes recorded: %+v", last.Changes)
+	}
+}
+
+func TestHeartbeatDeregisterHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = srv.server.clusterManager.RegisterNode(ctx, c.ID, &types.Node{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		method string
+		path   string
+		code   int
+		phase  types.ClusterPhase
+	}{
+		{http.MethodPost, "/clusters/" + c.ID + "/nodes/1/heartbeat", http.StatusOK, types.ClusterPhaseActive},
+		{http.MethodPost, "/clusters/" + c.ID + "/nodes/2/heartbeat", http.StatusNotFound, ""},
+		{http.MethodPost, "/clusters/unknown/nodes/1/heartbeat", http.StatusNotFound, ""},
+		{http.MethodDelete, "/clusters/" + c.ID + "/nodes/1", http.StatusOK, types.ClusterPhaseDegraded},
+		{http.MethodDelete, "/clusters/" + c.ID + "/nodes/1", http.StatusNotFound, ""},
+	}
+
+	for _, step := range steps {
+		req, err := http.NewRequest(step.method, step.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+
+		if resp.Code != step.code {
+			t.Errorf("%s %s: got code %d, wanted %d", step.method, step.path, resp.Code, step.code)
+			continue
+		}
+		if step.phase == "" {
+			continue
+		}
+
+		var updated types.Cluster
+		if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if updated.Phase != step.phase {
+			t.Errorf("%s %s: got phase %s, wanted %s", step.method, step.path, updated.Phase, step.phase)
+		}
+	}
+
+	transitions, err := srv.server.auditLog.Query(ctx, audit.Query{ClusterID: c.ID, Action: audit.ActionPhase})
+	if err != nil {
+		t.Fatalf("failed to query audit log: %v", err)
+	}
+	if len(transitions) != 3 {
+		t.Fatalf("expected 3 phase transitions in audit log, got %d", len(transitions))
+	}
+	last := transitions[2].Changes[0]
+	if last.From != string(types.ClusterPhaseActive) || last.To != string(types.ClusterPhaseDegraded) {
+		t.Errorf("unexpected last phase transition: %+v", last)
+	}
+}
+
+func TestUpdateClusterHandler(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/clusters/"+c.ID, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	srv.server.mux.ServeHTTP(resp, req)
+	tag := resp.Header().Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("unexpected ETag: %s", tag)
+	}
+
+	tests := []struct {
+		ifMatch string
+		body    string
+		code    int
+	}{
+		{tag, `{"name": "renamed", "size": 5}`, http.StatusOK},
+		{tag, `{"name": "stale"}`, http.StatusPreconditionFailed},
+		{"1", `{"name": "unquoted"}`, http.StatusPreconditionFailed},
+		{"", `{"size": 0}`, http.StatusBadRequest},
+		{"*", `{"ttl": 3600}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPatch, "/clusters/"+c.ID, bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+
+		if resp.Code != tt.code {
+			t.Errorf("%s %s: got code %d, wanted %d (%s)", tt.ifMatch, tt.body, resp.Code, tt.code, resp.Body.String())
+		}
+	}
+
+	updated, err := srv.server.clusterManager.Get(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "renamed" || updated.Size != 5 || updated.ExpiresAt == nil || updated.Version != 3 {
+		t.Errorf("unexpected cluster after updates: %+v", updated)
+	}
+}
+
+func TestClusterHandlerConditionalGet(t *testing.T) {
+	ctx := context.Background()
+
+	srv := setupTestServer(t)
+	defer teardownTestServer(t, srv)
+
+	c, err := srv.server.clusterManager.Create(ctx, types.ClusterCreateOps{Size: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(http.MethodGet, "/clusters/"+c.ID, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp := httptest.NewRecorder()
+		srv.server.mux.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("", "")
+	tag, lastModified := resp.Header().Get("ETag"), resp.Header().Get("Last-Modified")
+	if resp.Code != http.StatusOK || tag == "" || lastModified == "" {
+		t.Fatalf("expected validators in response, got code %d, ETag %q, Last-Modified %q", resp.Code, tag, lastModified)
+	}
+
+	if resp := get("If-None-Match", tag); resp.Code != http.StatusNotModified || resp.Body.Len() != 0 {
+		t.Errorf("expected 304 for matching ETag, got %d", resp.Code)
+	}
+	if resp := get("If-None-Match", `"other", W/`+tag); resp.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching weak ETag in list, got %d", resp.Code)
+	}
+	if resp := get("If-Modified-Since", lastModified); resp.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for unchanged Last-Modified, got %d", resp.Code)
+	}
+
+	time.Sleep(time.Second)
+	_, updated, err := srv.server.clusterManager.RegisterNode(ctx, c.ID, &types.Node{ID: "1", Name: "node1", AdvertiseAddress: "192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.UpdatedAt.After(c.UpdatedAt) {
+		t.Errorf("expected registration to bump UpdatedAt")
+	}
+
+	if resp := get("If-None-Match", tag); resp.Code != http.StatusOK {
+		t.Errorf("expected 200 for stale ETag, got %d", resp.Code)
+	}
+	if resp := get("If-Modified-Since", lastModified); resp.Code != http.StatusOK {
+		t.Errorf("expected 200 after modification, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/migrate"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "source store, e.g. bolt:///db/discovery.db")
+	fromCodec := fs.String("from-codec", "gob", "source record codec (gob or json)")
+	to := fs.String("to", "", "destination store, e.g. bolt:///db/discovery-json.db")
+	toCodec := fs.String("to-codec", "gob", "destination record codec (gob or json)")
+	dryRun := fs.Bool("dry-run", false, "decode and checksum source records without writing")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *from == "" || *to == "" {
+		fmt.Fprintln(os.Stderr, "both -from and -to are required")
+		fs.Usage()
+		return 2
+	}
+
+	src, closeSrc, err := openEndpoint(*from, *fromCodec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open source: %s\n", err)
+		return 1
+	}
+	defer closeSrc()
+
+	dst, closeDst, err := openEndpoint(*to, *toCodec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open destination: %s\n", err)
+		return 1
+	}
+	defer closeDst()
+
+	report, err := migrate.Run(context.Background(), src, dst, migrate.Options{DryRun: *dryRun})
+	if report != nil {
+		fmt.Printf("total: %d, copied: %d, skipped: %d, verified: %d\n",
+			report.Total, report.Copied, report.Skipped, report.Verified)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migration failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// openEndpoint - opens store described by the spec, a bare path is
+// treated as a bolt database
+func openEndpoint(spec, codec string) (migrate.Endpoint, func(), error) {
+	serializer, err := codecs.New(codec)
+	if err != nil {
+		return migrate.Endpoint{}, nil, err
+	}
+
+	backend, path := "bolt", spec
+	if i := strings.Index(spec, "://"); i >= 0 {
+		backend, path = spec[:i], spec[i+3:]
+	}
+
+	var st store.Store
+	var buckets map[string]store.Store
+	var closeFn func()
+	switch backend {
+	case "bolt":
+		db, err := boltdb.New(path)
+		if err != nil {
+			return migrate.Endpoint{}, nil, err
+		}
+		st, closeFn = db, db.Close
+
+		buckets = map[string]store.Store{}
+		for _, name := range []string{account.Bucket, audit.Bucket} {
+			if buckets[name], err = db.Bucket(name); err != nil {
+				db.Close()
+				return migrate.Endpoint{}, nil, err
+			}
+		}
+	default:
+		return migrate.Endpoint{}, nil, fmt.Errorf("unsupported store backend: %s", backend)
+	}
+
+	return migrate.Endpoint{Store: st, Serializer: serializer, Buckets: buckets}, closeFn, nil
+}
@@ -0,0 +1,198 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+// Endpoint - store and serializer pair records are read from or written to
+type Endpoint struct {
+	Store      store.Store
+	Serializer codecs.Serializer
+	// Buckets - stores of records other than clusters sharing the
+	// serializer, by bucket name, e.g. accounts and the audit log
+	Buckets map[string]store.Store
+}
+
+// bucketRecords - record types of the buckets stored next to clusters
+var bucketRecords = map[string]func(key string) interface{}{
+	account.Bucket: account.Record,
+	audit.Bucket:   audit.Record,
+}
+
+func clusterRecord(key string) interface{} {
+	return &types.Cluster{}
+}
+
+// Options - migration options
+type Options struct {
+	// DryRun decodes and checksums every source record without writing
+	// anything to the destination
+	DryRun bool
+}
+
+// Report - migration summary
+type Report struct {
+	// Total number of records found in the source store
+	Total int `json:"total"`
+	// Copied records that were written to the destination
+	Copied int `json:"copied"`
+	// Skipped records that were already present in the destination with
+	// a matching checksum, this is what makes migrations resumable
+	Skipped int `json:"skipped"`
+	// Verified records that were read back from the destination and
+	// matched the source checksum
+	Verified int `json:"verified"`
+}
+
+// ChecksumMismatchError - returned when a record read back from the
+// destination doesn't match the source record
+type ChecksumMismatchError struct {
+	Key         string
+	Source      string
+	Destination string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for key %s: source %s, destination %s", e.Key, e.Source, e.Destination)
+}
+
+// Run - streams every record from src into dst, re-encoding it with the
+// destination serializer. Clusters are migrated first, then every bucket
+// of the source into the destination bucket of the same name. Records that
+// already exist in the destination with a matching checksum are skipped so
+// an interrupted migration can be started again.
+func Run(ctx context.Context, src, dst Endpoint, opts Options) (*Report, error) {
+	report := &Report{}
+
+	if err := copyRecords(ctx, src.Serializer, dst.Serializer, src.Store, dst.Store, clusterRecord, opts, report); err != nil {
+		return report, err
+	}
+
+	names := make([]string, 0, len(src.Buckets))
+	for name := range src.Buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		records, ok := bucketRecords[name]
+		if !ok {
+			return report, fmt.Errorf("unknown bucket %s", name)
+		}
+		to, ok := dst.Buckets[name]
+		if !ok {
+			return report, fmt.Errorf("bucket %s missing in destination", name)
+		}
+		if err := copyRecords(ctx, src.Serializer, dst.Serializer, src.Buckets[name], to, records, opts, report); err != nil {
+			return report, fmt.Errorf("bucket %s: %w", name, err)
+		}
+	}
+
+	if !opts.DryRun && report.Verified != report.Total {
+		return report, fmt.Errorf("record count mismatch: %d in source, %d verified in destination", report.Total, report.Verified)
+	}
+
+	return report, nil
+}
+
+// copyRecords - migrates records of a single store, records returns the
+// value the record under a key decodes into
+func copyRecords(ctx context.Context, srcSer, dstSer codecs.Serializer, src, dst store.Store, records func(key string) interface{}, opts Options, report *Report) error {
+	kvps, err := src.Enumerate(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to enumerate source: %s", err)
+	}
+	report.Total += len(kvps)
+
+	for _, kvp := range kvps {
+		record := records(kvp.Key)
+		if record == nil {
+			return fmt.Errorf("unknown record type of source key %s", kvp.Key)
+		}
+		if err := srcSer.Decode(kvp.Value, record); err != nil {
+			return fmt.Errorf("failed to decode source key %s: %s", kvp.Key, err)
+		}
+
+		sum, err := checksum(record)
+		if err != nil {
+			return fmt.Errorf("failed to checksum source key %s: %s", kvp.Key, err)
+		}
+
+		existing, err := checksumAt(ctx, dst, dstSer, kvp.Key, records)
+		if err != nil {
+			return err
+		}
+		if existing == sum {
+			report.Skipped++
+			report.Verified++
+			continue
+		}
+
+		if opts.DryRun {
+			continue
+		}
+
+		bts, err := dstSer.Encode(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode key %s: %s", kvp.Key, err)
+		}
+
+		_, err = dst.Put(ctx, kvp.Key, bts, kvp.TTL)
+		if err != nil {
+			return fmt.Errorf("failed to write key %s: %s", kvp.Key, err)
+		}
+		report.Copied++
+
+		written, err := checksumAt(ctx, dst, dstSer, kvp.Key, records)
+		if err != nil {
+			return err
+		}
+		if written != sum {
+			return &ChecksumMismatchError{Key: kvp.Key, Source: sum, Destination: written}
+		}
+		report.Verified++
+	}
+	return nil
+}
+
+// checksumAt - returns checksum of the record stored under the given key
+// in the store or an empty string if the key is not present
+func checksumAt(ctx context.Context, st store.Store, serializer codecs.Serializer, key string, records func(key string) interface{}) (string, error) {
+	kvp, err := st.Get(ctx, key)
+	if err == store.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read destination key %s: %s", key, err)
+	}
+
+	record := records(key)
+	if err := serializer.Decode(kvp.Value, record); err != nil {
+		// undecodable records are overwritten
+		return "", nil
+	}
+	return checksum(record)
+}
+
+// Checksum - serializer independent checksum of the cluster
+func Checksum(cluster *types.Cluster) (string, error) {
+	return checksum(cluster)
+}
+
+func checksum(record interface{}) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(record); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())), nil
+}
@@ -0,0 +1,173 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/storageos/discovery/account"
+	"github.com/storageos/discovery/audit"
+	"github.com/storageos/discovery/cluster"
+	"github.com/storageos/discovery/store"
+	"github.com/storageos/discovery/store/boltdb"
+	"github.com/storageos/discovery/types"
+	"github.com/storageos/discovery/util/codecs"
+)
+
+func TestMigrateGobToJSON(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "testmigrate")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+
+	srcDB, err := boltdb.New(dir + "/src.db")
+	if err != nil {
+		t.Fatalf("failed to create db: %s", err)
+	}
+	defer srcDB.Close()
+
+	dstDB, err := boltdb.New(dir + "/dst.db")
+	if err != nil {
+		t.Fatalf("failed to create db: %s", err)
+	}
+	defer dstDB.Close()
+
+	src := Endpoint{Store: srcDB, Serializer: &codecs.GobSerializer{}}
+	dst := Endpoint{Store: dstDB, Serializer: &codecs.JSONSerializer{}}
+
+	cm := cluster.New(srcDB, src.Serializer)
+	for i := 0; i < 5; i++ {
+		c, err := cm.Create(ctx, types.ClusterCreateOps{Name: fmt.Sprintf("cluster-%d", i)})
+		if err != nil {
+			t.Fatalf("failed to create cluster: %s", err)
+		}
+		_, _, err = cm.RegisterNode(ctx, c.ID, &types.Node{ID: "1", Name: "node-1", AdvertiseAddress: "10.0.0.1"})
+		if err != nil {
+			t.Fatalf("failed to register node: %s", err)
+		}
+	}
+
+	report, err := Run(ctx, src, dst, Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %s", err)
+	}
+	if report.Total != 5 || report.Copied != 0 {
+		t.Errorf("unexpected dry run report: %+v", report)
+	}
+	kvps, err := dstDB.Enumerate(ctx, "")
+	if err != nil {
+		t.Fatalf("failed to enumerate destination: %s", err)
+	}
+	if len(kvps) != 0 {
+		t.Errorf("dry run wrote %d records", len(kvps))
+	}
+
+	report, err = Run(ctx, src, dst, Options{})
+	if err != nil {
+		t.Fatalf("migration failed: %s", err)
+	}
+	if report.Copied != 5 || report.Verified != 5 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// running again resumes and skips already migrated records
+	report, err = Run(ctx, src, dst, Options{})
+	if err != nil {
+		t.Fatalf("second migration failed: %s", err)
+	}
+	if report.Copied != 0 || report.Skipped != 5 {
+		t.Errorf("unexpected resumed report: %+v", report)
+	}
+
+	migrated, err := cluster.New(dstDB, dst.Serializer).Get(ctx, firstKey(t, srcDB))
+	if err != nil {
+		t.Fatalf("failed to read migrated cluster: %s", err)
+	}
+	if len(migrated.Nodes) != 1 {
+		t.Errorf("unexpected number of nodes in migrated cluster: %d", len(migrated.Nodes))
+	}
+}
+
+func firstKey(t *testing.T, db *boltdb.Store) string {
+	ctx := context.Background()
+
+	kvps, err := db.Enumerate(ctx, "")
+	if err != nil || len(kvps) == 0 {
+		t.Fatalf("failed to enumerate source: %v", err)
+	}
+	return kvps[0].Key
+}
+
+func TestMigrateBuckets(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "testmigratebuckets")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+
+	endpoint := func(path string, serializer codecs.Serializer) Endpoint {
+		db, err := boltdb.New(path)
+		if err != nil {
+			t.Fatalf("failed to create db: %s", err)
+		}
+		t.Cleanup(db.Close)
+
+		e := Endpoint{Store: db, Serializer: serializer, Buckets: map[string]store.Store{}}
+		for _, name := range []string{account.Bucket, audit.Bucket} {
+			if e.Buckets[name], err = db.Bucket(name); err != nil {
+				t.Fatalf("failed to create bucket: %s", err)
+			}
+		}
+		return e
+	}
+	src := endpoint(dir+"/src.db", &codecs.GobSerializer{})
+	dst := endpoint(dir+"/dst.db", &codecs.JSONSerializer{})
+
+	accounts := account.New(src.Buckets[account.Bucket], src.Serializer)
+	if _, err := accounts.Create(ctx, &types.Account{ID: "acme", Quota: types.Quota{MaxClusters: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	apiKey, err := accounts.CreateKey(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := audit.New(src.Buckets[audit.Bucket], src.Serializer, 0)
+	if err := log.Record(ctx, &audit.Entry{ClusterID: "c1", Action: audit.ActionCreate}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(ctx, src, dst, Options{})
+	if err != nil {
+		t.Fatalf("migration failed: %s", err)
+	}
+	if report.Total != 3 || report.Verified != 3 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	// tenants, their keys and history survive the migration
+	migrated := account.New(dst.Buckets[account.Bucket], dst.Serializer)
+	acc, err := migrated.Get(ctx, "acme")
+	if err != nil {
+		t.Fatalf("failed to read migrated account: %s", err)
+	}
+	if acc.Quota.MaxClusters != 2 {
+		t.Errorf("unexpected migrated quota: %+v", acc.Quota)
+	}
+	if accountID, _, err := migrated.Authenticate(ctx, apiKey.Key); err != nil || accountID != "acme" {
+		t.Errorf("expected migrated key to authenticate, got %q: %v", accountID, err)
+	}
+	history, err := audit.New(dst.Buckets[audit.Bucket], dst.Serializer, 0).History(ctx, "c1")
+	if err != nil || len(history) != 1 {
+		t.Errorf("expected migrated audit entry, got %d: %v", len(history), err)
+	}
+
+	// buckets the destination doesn't have aren't dropped silently
+	delete(dst.Buckets, audit.Bucket)
+	if _, err := Run(ctx, src, dst, Options{}); err == nil {
+		t.Errorf("expected migration without destination bucket to fail")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/storageos/discovery/client"
+)
+
+func adminClientFlags(fs *flag.FlagSet) (endpoint, token *string) {
+	endpoint = fs.String("endpoint", "http://127.0.0.1:8081", "discovery server endpoint")
+	token = fs.String("token", os.Getenv(EnvAdminToken), "admin token, defaults to $"+EnvAdminToken)
+	return endpoint, token
+}
+
+func runSnapshot(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	endpoint, token := adminClientFlags(fs)
+	out := fs.String("o", "", "output file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "-o is required")
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create output file: %s\n", err)
+		return 1
+	}
+
+	c := client.New(client.WithEndpoint(*endpoint), client.WithAdminToken(*token))
+	n, err := c.Snapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "snapshot failed: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("snapshot written to %s (%d bytes)\n", *out, n)
+	return 0
+}
+
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	endpoint, token := adminClientFlags(fs)
+	in := fs.String("i", "", "snapshot file to restore")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "-i is required")
+		fs.Usage()
+		return 2
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open snapshot: %s\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	c := client.New(client.WithEndpoint(*endpoint), client.WithAdminToken(*token))
+	if err := c.Restore(f); err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("snapshot %s restored\n", *in)
+	return 0
+}
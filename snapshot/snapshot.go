@@ -0,0 +1,154 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/storageos/discovery/util/logging"
+)
+
+const (
+	filePrefix = "discovery-"
+	fileSuffix = ".db"
+	timeLayout = "20060102T150405.000000000Z"
+)
+
+// Source - anything that can write a consistent snapshot of itself
+type Source interface {
+	Snapshot(ctx context.Context, w io.Writer) (int64, error)
+}
+
+// Scheduler - periodically writes snapshots into a local directory and
+// prunes old ones
+type Scheduler struct {
+	source   Source
+	dir      string
+	interval time.Duration
+	retain   int
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewScheduler - create new snapshot scheduler, retain is the number of
+// most recent snapshots kept in dir, 0 keeps all of them
+func NewScheduler(source Source, dir string, interval time.Duration, retain int) *Scheduler {
+	return &Scheduler{
+		source:   source,
+		dir:      dir,
+		interval: interval,
+		retain:   retain,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start - starts taking snapshots in the background
+func (s *Scheduler) Start() {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				path, err := s.Take()
+				if err != nil {
+					slog.Error("scheduled snapshot failed", logging.FieldError, err)
+					continue
+				}
+				slog.Info("scheduled snapshot written", "path", path)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop - stops scheduler and waits for a running snapshot to finish
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// Take - writes a single snapshot into the directory and prunes old
+// snapshots, the path of the new snapshot is returned
+func (s *Scheduler) Take() (string, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, ".snapshot-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = s.source.Snapshot(context.Background(), tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(s.dir, filePrefix+time.Now().UTC().Format(timeLayout)+fileSuffix)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, s.prune()
+}
+
+// List - returns snapshot paths in the directory, oldest first
+func (s *Scheduler) List() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		paths = append(paths, filepath.Join(s.dir, name))
+	}
+	// timestamps sort lexicographically
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (s *Scheduler) prune() error {
+	if s.retain <= 0 {
+		return nil
+	}
+
+	paths, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for len(paths) > s.retain {
+		if err := os.Remove(paths[0]); err != nil {
+			return fmt.Errorf("failed to prune snapshot %s: %s", paths[0], err)
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
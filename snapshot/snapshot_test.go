@@ -0,0 +1,55 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type stringSource string
+
+func (s stringSource) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, string(s))
+	return int64(n), err
+}
+
+func TestSchedulerRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testsnapshot")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewScheduler(stringSource("snapshot"), dir, 0, 3)
+
+	var last string
+	for i := 0; i < 5; i++ {
+		last, err = s.Take()
+		if err != nil {
+			t.Fatalf("failed to take snapshot: %s", err)
+		}
+	}
+
+	paths, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list snapshots: %s", err)
+	}
+
+	if len(paths) != 3 {
+		t.Errorf("expected 3 snapshots to be retained, got %d", len(paths))
+	}
+
+	if paths[len(paths)-1] != last {
+		t.Errorf("expected newest snapshot %s to be retained, got %v", last, paths)
+	}
+
+	bts, err := ioutil.ReadFile(last)
+	if err != nil {
+		t.Fatalf("failed to read snapshot: %s", err)
+	}
+	if string(bts) != "snapshot" {
+		t.Errorf("unexpected snapshot contents: %s", bts)
+	}
+}
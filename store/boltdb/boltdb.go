@@ -2,6 +2,12 @@ package boltdb
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -11,71 +17,236 @@ import (
 	"github.com/boltdb/bolt"
 )
 
+// maxBatchDelay - how long a put waits for others to share its transaction
+const maxBatchDelay = time.Millisecond
+
 type Store struct {
-	db               *bolt.DB
-	mu               *sync.Mutex
+	h                *handle
+	mu               *sync.Mutex // guards expiry and index
+	expiry           map[string]uint64
 	index            uint64
 	tokensBucketName []byte
+	// validate checks records of the bucket in snapshots being restored
+	validate func(*store.KVPair) error
+}
+
+// handle - bolt database shared by stores backed by different buckets
+type handle struct {
+	mu      sync.RWMutex // guards db while a snapshot is being restored
+	db      *bolt.DB
+	path    string
+	buckets [][]byte
+	stores  []*Store
 }
 
 func New(path string) (*Store, error) {
+	db, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return newStore(&handle{db: db, path: path}, []byte("tokens"))
+}
+
+// open - opens the bolt database at path. Puts from concurrent requests are
+// batched into a single transaction, the delay is kept short so that a lone
+// write isn't held back for long.
+func open(path string) (*bolt.DB, error) {
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
+	db.MaxBatchDelay = maxBatchDelay
+	return db, nil
+}
 
+func newStore(h *handle, bucket []byte) (*Store, error) {
 	st := &Store{
-		db:               db,
+		h:                h,
 		mu:               &sync.Mutex{},
-		tokensBucketName: []byte("tokens"),
+		expiry:           make(map[string]uint64),
+		tokensBucketName: bucket,
 	}
+
+	h.mu.Lock()
+	h.buckets = append(h.buckets, bucket)
+	h.stores = append(h.stores, st)
+	h.mu.Unlock()
+
 	// ensure bucket
-	err = st.ensureBuckets()
+	err := st.update(context.Background(), h.ensureBuckets)
 	if err != nil {
 		return nil, err
 	}
 	return st, nil
 }
 
-func (s *Store) ensureBuckets() error {
-	// store some data
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(s.tokensBucketName)
+// Bucket - returns store backed by a separate bucket in the same database,
+// records in it are included in snapshots
+func (s *Store) Bucket(name string) (*Store, error) {
+	return newStore(s.h, []byte(name))
+}
+
+// SetValidator - records of the bucket in snapshots being restored have to
+// pass fn, the validator passed to Restore applies to its own store
+func (s *Store) SetValidator(fn func(*store.KVPair) error) {
+	s.h.mu.Lock()
+	s.validate = fn
+	s.h.mu.Unlock()
+}
+
+func (h *handle) ensureBuckets(tx *bolt.Tx) error {
+	for _, name := range h.buckets {
+		_, err := tx.CreateBucketIfNotExists(name)
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// batch - like update, but concurrent writes are coalesced into a single
+// transaction. fn may run more than once so it must be idempotent.
+func (s *Store) batch(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.h.mu.RLock()
+	defer s.h.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.h.db.Batch(fn)
+}
+
+// view - runs read transaction unless ctx is done, also once waiting for a
+// restore to finish
+func (s *Store) view(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.h.mu.RLock()
+	defer s.h.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.h.db.View(fn)
+}
+
+// update - runs write transaction unless ctx is done, transactions aren't
+// interrupted once they started
+func (s *Store) update(ctx context.Context, fn func(*bolt.Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.h.mu.RLock()
+	defer s.h.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.h.db.Update(fn)
+}
+
+// Size - size of the database file in bytes, shared by all buckets
+func (s *Store) Size() (int64, error) {
+	var size int64
+	err := s.view(context.Background(), func(tx *bolt.Tx) error {
+		size = tx.Size()
 		return nil
 	})
+	return size, err
+}
 
-	return err
+// Stats - statistics of the database, shared by all buckets
+func (s *Store) Stats() bolt.Stats {
+	s.h.mu.RLock()
+	defer s.h.mu.RUnlock()
+	return s.h.db.Stats()
 }
 
 func (s *Store) Close() {
-	s.db.Close()
+	s.h.mu.Lock()
+	defer s.h.mu.Unlock()
+	s.h.db.Close()
 }
 
-func (s *Store) Create(key string, value []byte, ttl int64) (*store.KVPair, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Create - writes key unless it exists, the check and the write happen in
+// the same transaction
+func (s *Store) Create(ctx context.Context, key string, value []byte, ttl int64) (*store.KVPair, error) {
+	err := s.batch(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.tokensBucketName)
+		if b.Get([]byte(key)) != nil {
+			return store.ErrExist
+		}
+		return b.Put([]byte(key), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.scheduleExpiry(key, ttl, s.expire(key, ttl))
 
-	_, err := s.Get(key)
+	return &store.KVPair{
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	}, nil
+}
+
+func (s *Store) Put(ctx context.Context, key string, value []byte, ttl int64) (*store.KVPair, error) {
+	err := s.batch(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(s.tokensBucketName).Put([]byte(key), value)
+	})
 	if err != nil {
-		return s.put(key, value, ttl)
+		return nil, err
 	}
+	s.scheduleExpiry(key, ttl, s.expire(key, ttl))
 
-	return nil, store.ErrExist
+	return &store.KVPair{
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	}, nil
 }
 
-func (s *Store) Put(key string, value []byte, ttl int64) (*store.KVPair, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Update - read-modify-write of key in a single transaction. fn gets a copy
+// of the current value, nil if the key doesn't exist, and returns the new
+// one. Returning a nil value leaves the key unchanged, an error aborts the
+// transaction. fn can be called more than once so it must not have side
+// effects other than its result. Expiry set by an earlier write is kept.
+func (s *Store) Update(ctx context.Context, key string, fn func(old []byte) ([]byte, error)) (*store.KVPair, error) {
+	var kvp *store.KVPair
+	err := s.batch(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.tokensBucketName)
+
+		var old []byte
+		if v := b.Get([]byte(key)); v != nil {
+			old = make([]byte, len(v))
+			copy(old, v)
+		}
 
-	return s.put(key, value, ttl)
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			kvp = &store.KVPair{Key: key, Value: old}
+			return nil
+		}
+		if err := b.Put([]byte(key), value); err != nil {
+			return err
+		}
+		kvp = &store.KVPair{Key: key, Value: value}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kvp, nil
 }
 
-func (s *Store) Get(key string) (*store.KVPair, error) {
+func (s *Store) Get(ctx context.Context, key string) (*store.KVPair, error) {
 	buf := bytes.Buffer{}
-	err := s.db.View(func(tx *bolt.Tx) error {
+	err := s.view(ctx, func(tx *bolt.Tx) error {
 		b := tx.Bucket(s.tokensBucketName)
 		v := b.Get([]byte(key))
 		if v == nil {
@@ -94,36 +265,206 @@ func (s *Store) Get(key string) (*store.KVPair, error) {
 	}, nil
 }
 
-func (s *Store) put(key string, value []byte, ttl int64) (*store.KVPair, error) {
-	var err error
+func (s *Store) Enumerate(ctx context.Context, prefix string) (store.KVPairs, error) {
+	var kvps store.KVPairs
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		kvps = enumerate(tx.Bucket(s.tokensBucketName), prefix)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kvps, nil
+}
 
-	if ttl != 0 {
-		time.AfterFunc(time.Second*time.Duration(ttl), func() {
-			s.Delete(key)
+func enumerate(b *bolt.Bucket, prefix string) store.KVPairs {
+	var kvps store.KVPairs
+	c := b.Cursor()
+	p := []byte(prefix)
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		value := make([]byte, len(v))
+		copy(value, v)
+		kvps = append(kvps, &store.KVPair{
+			Key:   string(k),
+			Value: value,
 		})
 	}
+	return kvps
+}
 
-	err = s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(s.tokensBucketName)
-		err := b.Put([]byte(key), value)
+func (s *Store) Delete(ctx context.Context, key string) error {
+	err := s.update(ctx, func(tx *bolt.Tx) error {
+		return tx.Bucket(s.tokensBucketName).Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+	s.expire(key, 0)
+	return nil
+}
+
+// expire - records that the key written by a committed transaction expires
+// after ttl seconds, returning the generation of the write. A key without
+// TTL doesn't expire, also when an earlier write set one. It's called once
+// the write succeeded, batched transactions can be retried and failed ones
+// must not change expiry of the key.
+func (s *Store) expire(key string, ttl int64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ttl == 0 {
+		delete(s.expiry, key)
+		return 0
+	}
+	s.index++
+	s.expiry[key] = s.index
+	return s.index
+}
+
+// scheduleExpiry - deletes the key once ttl passes unless it was written
+// again in the meantime
+func (s *Store) scheduleExpiry(key string, ttl int64, gen uint64) {
+	if ttl == 0 {
+		return
+	}
+	time.AfterFunc(time.Second*time.Duration(ttl), func() {
+		s.update(context.Background(), func(tx *bolt.Tx) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.expiry[key] != gen {
+				return nil
+			}
+			delete(s.expiry, key)
+			return tx.Bucket(s.tokensBucketName).Delete([]byte(key))
+		})
+	})
+}
+
+// Snapshot - writes a consistent copy of the whole database to w from a
+// single read transaction, writes are not blocked while it runs
+func (s *Store) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	var n int64
+	err := s.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
 		return err
 	})
+	return n, err
+}
 
+// Restore - replaces the database with the snapshot read from r, all
+// buckets sharing the database are restored. Snapshot is written next to the
+// database and every record of the store's bucket is passed to validate,
+// records of the other buckets to their validators, before it is swapped in.
+// The previous database is kept with a .bak suffix.
+func (s *Store) Restore(ctx context.Context, r io.Reader, validate func(*store.KVPair) error) error {
+	h := s.h
+	tmp, err := ioutil.TempFile(filepath.Dir(h.path), filepath.Base(h.path)+".restore-")
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return &store.KVPair{
-		Key:   key,
-		Value: value,
-		TTL:   ttl,
-	}, nil
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %s", err)
+	}
+
+	if err := s.validateSnapshot(tmp.Name(), validate); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// last chance to back out, the swap isn't interrupted
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := h.db.Close(); err != nil {
+		return err
+	}
+
+	backup := h.path + ".bak"
+	if err := os.Rename(h.path, backup); err != nil {
+		return h.reopen(fmt.Errorf("failed to back up database: %s", err))
+	}
+
+	if err := os.Rename(tmp.Name(), h.path); err != nil {
+		os.Rename(backup, h.path)
+		return h.reopen(fmt.Errorf("failed to swap in snapshot: %s", err))
+	}
+	h.resetExpiry()
+
+	return h.reopen(nil)
 }
 
-func (s *Store) Delete(key string) error {
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(s.tokensBucketName).Delete([]byte(key))
+// resetExpiry - forgets expiry of keys written before a restore in all
+// stores sharing the handle, pending expiries then leave restored keys
+// alone. Snapshots don't carry TTLs so restored keys don't expire.
+func (h *handle) resetExpiry() {
+	for _, st := range h.stores {
+		st.mu.Lock()
+		st.expiry = make(map[string]uint64)
+		st.mu.Unlock()
+	}
+}
+
+func (s *Store) validateSnapshot(path string, validate func(*store.KVPair) error) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("%w: %s", store.ErrInvalidSnapshot, err)
+	}
+	defer db.Close()
+
+	s.h.mu.RLock()
+	validators := make(map[string]func(*store.KVPair) error, len(s.h.stores))
+	for _, st := range s.h.stores {
+		validators[string(st.tokensBucketName)] = st.validate
+	}
+	s.h.mu.RUnlock()
+	validators[string(s.tokensBucketName)] = validate
+
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(s.tokensBucketName) == nil {
+			return fmt.Errorf("%w: bucket %s not found", store.ErrInvalidSnapshot, s.tokensBucketName)
+		}
+
+		// other buckets may be missing from snapshots taken before they
+		// were added, they are created empty once the snapshot is swapped in
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			fn, ok := validators[string(name)]
+			if !ok {
+				return fmt.Errorf("%w: unknown bucket %s", store.ErrInvalidSnapshot, name)
+			}
+			if fn == nil {
+				return nil
+			}
+			for _, kvp := range enumerate(b, "") {
+				if err := fn(kvp); err != nil {
+					return fmt.Errorf("%w: bucket %s key %s: %s", store.ErrInvalidSnapshot, name, kvp.Key, err)
+				}
+			}
+			return nil
+		})
 	})
+}
 
-	return err
+// reopen - opens database at the handle path and ensures buckets of all
+// stores sharing it exist, must be called with mu held
+func (h *handle) reopen(cause error) error {
+	db, err := open(h.path)
+	if err != nil {
+		return fmt.Errorf("failed to reopen database: %s", err)
+	}
+	h.db = db
+	if err := db.Update(h.ensureBuckets); err != nil {
+		return fmt.Errorf("failed to reopen database: %s", err)
+	}
+	return cause
 }
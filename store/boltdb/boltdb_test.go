@@ -0,0 +1,232 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/storageos/discovery/store"
+)
+
+func setupStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "teststore")
+	if err != nil {
+		t.Fatalf("failed to get temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s, err := New(filepath.Join(dir, "testdb"))
+	if err != nil {
+		t.Fatalf("failed to create db: %s", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestCreateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := setupStore(t)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created []string
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("value-%d", i)
+			_, err := s.Create(ctx, "key", []byte(value), 0)
+			if err == store.ErrExist {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			created = append(created, value)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(created) != 1 {
+		t.Fatalf("expected exactly one create to succeed, got %d", len(created))
+	}
+	kvp, err := s.Get(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kvp.Value) != created[0] {
+		t.Errorf("expected value of the successful create %s, got %s", created[0], kvp.Value)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := setupStore(t)
+
+	increment := func(old []byte) ([]byte, error) {
+		var n int
+		if old != nil {
+			fmt.Sscan(string(old), &n)
+		}
+		return []byte(fmt.Sprint(n + 1)), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Update(ctx, "counter", increment); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	kvp, err := s.Get(ctx, "counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kvp.Value) != "16" {
+		t.Errorf("expected no lost updates, got counter %s", kvp.Value)
+	}
+
+	// nil value leaves key unchanged
+	kvp, err = s.Update(ctx, "counter", func(old []byte) ([]byte, error) { return nil, nil })
+	if err != nil || string(kvp.Value) != "16" {
+		t.Errorf("expected unchanged value, got %v: %v", kvp, err)
+	}
+
+	// error aborts without writing
+	failed := errors.New("failed")
+	_, err = s.Update(ctx, "other", func(old []byte) ([]byte, error) { return []byte("x"), failed })
+	if err != failed {
+		t.Errorf("expected fn error, got %v", err)
+	}
+	if _, err := s.Get(ctx, "other"); err != store.ErrNotFound {
+		t.Errorf("expected aborted update not to write, got %v", err)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := setupStore(t)
+
+	if _, err := s.Put(ctx, "expiring", []byte("1"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, "rewritten", []byte("1"), 1); err != nil {
+		t.Fatal(err)
+	}
+	// rewriting without TTL keeps the key
+	if _, err := s.Put(ctx, "rewritten", []byte("2"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	if _, err := s.Get(ctx, "expiring"); err != store.ErrNotFound {
+		t.Errorf("expected key to expire, got %v", err)
+	}
+	kvp, err := s.Get(ctx, "rewritten")
+	if err != nil {
+		t.Fatalf("expected rewritten key to stay: %s", err)
+	}
+	if string(kvp.Value) != "2" {
+		t.Errorf("unexpected value %s", kvp.Value)
+	}
+}
+
+func TestRestoreResetsExpiry(t *testing.T) {
+	ctx := context.Background()
+	s := setupStore(t)
+	other, err := s.Bucket("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, st := range []*Store{s, other} {
+		if _, err := st.Put(ctx, "expiring", []byte("1"), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := s.Snapshot(ctx, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Restore(ctx, &snapshot, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	// expiry of keys written before the restore doesn't apply to restored
+	// keys of any bucket
+	for _, st := range []*Store{s, other} {
+		if _, err := st.Get(ctx, "expiring"); err != nil {
+			t.Errorf("expected restored key in bucket %s to stay, got %v", st.tokensBucketName, err)
+		}
+	}
+}
+
+func TestRestoreValidatesBuckets(t *testing.T) {
+	ctx := context.Background()
+	s := setupStore(t)
+	other, err := s.Bucket("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errInvalid := errors.New("invalid record")
+	other.SetValidator(func(kvp *store.KVPair) error {
+		if string(kvp.Value) != "valid" {
+			return errInvalid
+		}
+		return nil
+	})
+
+	if _, err := other.Put(ctx, "key", []byte("invalid"), 0); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot bytes.Buffer
+	if _, err := s.Snapshot(ctx, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Put(ctx, "key", []byte("valid"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Restore(ctx, &snapshot, nil)
+	if !errors.Is(err, store.ErrInvalidSnapshot) {
+		t.Fatalf("expected invalid record of another bucket to be rejected, got %v", err)
+	}
+	if kvp, err := other.Get(ctx, "key"); err != nil || string(kvp.Value) != "valid" {
+		t.Errorf("expected database to be kept, got %v", err)
+	}
+
+	// snapshots of a different database are rejected
+	foreign := setupStore(t)
+	if _, err := foreign.Bucket("unknown"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Reset()
+	if _, err := foreign.Snapshot(ctx, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Restore(ctx, &snapshot, nil)
+	if !errors.Is(err, store.ErrInvalidSnapshot) {
+		t.Errorf("expected unknown bucket to be rejected, got %v", err)
+	}
+}
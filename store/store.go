@@ -1,7 +1,9 @@
 package store
 
 import (
+	"context"
 	"errors"
+	"io"
 )
 
 // KVPair represents the results of an operation on KVDB.
@@ -35,15 +37,41 @@ type KVPairs []*KVPair
 // Store - generic store interface
 type Store interface {
 	// Create is the same as Put except that ErrExist is returned if the key exists.
-	Create(key string, value []byte, ttl int64) (*KVPair, error)
+	Create(ctx context.Context, key string, value []byte, ttl int64) (*KVPair, error)
 
-	Put(key string, value []byte, ttl int64) (*KVPair, error)
+	Put(ctx context.Context, key string, value []byte, ttl int64) (*KVPair, error)
+
+	// Update atomically replaces the value of key with the one returned by
+	// fn. fn gets the current value, nil if the key doesn't exist, and can
+	// be called more than once. Returning a nil value leaves the key
+	// unchanged, an error is returned as is without writing anything.
+	Update(ctx context.Context, key string, fn func(old []byte) ([]byte, error)) (*KVPair, error)
 
 	// Get returns KVPair that maps to specified key or ErrNotFound.
-	Get(key string) (*KVPair, error)
+	Get(ctx context.Context, key string) (*KVPair, error)
+	// Enumerate returns a list of KVPairs for all keys that share the
+	// specified prefix, an empty prefix enumerates the whole store.
+	Enumerate(ctx context.Context, prefix string) (KVPairs, error)
 	// Delete deletes the KVPair specified by the key. ErrNotFound is returned
 	// if the key is not found. The old KVPair is returned if successful.
-	Delete(key string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Snapshotter - implemented by stores that can stream a consistent copy of
+// their contents and replace them with a previously taken snapshot
+type Snapshotter interface {
+	// Snapshot writes a consistent copy of the store to w.
+	Snapshot(ctx context.Context, w io.Writer) (int64, error)
+	// Restore replaces store contents with the snapshot read from r once
+	// validate accepted every KVPair in it.
+	Restore(ctx context.Context, r io.Reader, validate func(*KVPair) error) error
+}
+
+// Sizer - implemented by stores that know how much space they take up
+type Sizer interface {
+	// Size returns the size of the store in bytes, stores sharing a
+	// database report the size of the whole database.
+	Size() (int64, error)
 }
 
 var (
@@ -55,4 +83,10 @@ var (
 
 	// ErrValueMismatch raised if existing KVDB value mismatches with user provided value
 	ErrValueMismatch = errors.New("Value mismatch")
+
+	// ErrInvalidSnapshot raised if snapshot being restored fails validation
+	ErrInvalidSnapshot = errors.New("Invalid snapshot")
+
+	// ErrNotSupported raised if store doesn't support requested operation
+	ErrNotSupported = errors.New("Operation not supported")
 )
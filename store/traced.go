@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"io"
+
+	"github.com/storageos/discovery/tracing"
+)
+
+// traced - records a span for every store operation, optional interfaces
+// of the wrapped store stay available
+type traced struct {
+	store Store
+	name  string
+}
+
+// Traced - wraps store so operations show up in the trace of the calling
+// request, name tells apart stores sharing a database, e.g. its bucket
+func Traced(s Store, name string) Store {
+	return &traced{store: s, name: name}
+}
+
+func (t *traced) start(ctx context.Context, op, key string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "store."+op,
+		tracing.String("store.name", t.name),
+		tracing.String("store.key", key),
+	)
+}
+
+func (t *traced) Create(ctx context.Context, key string, value []byte, ttl int64) (*KVPair, error) {
+	ctx, span := t.start(ctx, "Create", key)
+	defer span.End()
+
+	kvp, err := t.store.Create(ctx, key, value, ttl)
+	span.RecordError(err)
+	return kvp, err
+}
+
+func (t *traced) Put(ctx context.Context, key string, value []byte, ttl int64) (*KVPair, error) {
+	ctx, span := t.start(ctx, "Put", key)
+	defer span.End()
+
+	kvp, err := t.store.Put(ctx, key, value, ttl)
+	span.RecordError(err)
+	return kvp, err
+}
+
+func (t *traced) Update(ctx context.Context, key string, fn func(old []byte) ([]byte, error)) (*KVPair, error) {
+	ctx, span := t.start(ctx, "Update", key)
+	defer span.End()
+
+	kvp, err := t.store.Update(ctx, key, fn)
+	span.RecordError(err)
+	return kvp, err
+}
+
+func (t *traced) Get(ctx context.Context, key string) (*KVPair, error) {
+	ctx, span := t.start(ctx, "Get", key)
+	defer span.End()
+
+	kvp, err := t.store.Get(ctx, key)
+	if err != ErrNotFound {
+		span.RecordError(err)
+	}
+	return kvp, err
+}
+
+func (t *traced) Enumerate(ctx context.Context, prefix string) (KVPairs, error) {
+	ctx, span := tracing.Start(ctx, "store.Enumerate",
+		tracing.String("store.name", t.name),
+		tracing.String("store.prefix", prefix),
+	)
+	defer span.End()
+
+	kvps, err := t.store.Enumerate(ctx, prefix)
+	span.RecordError(err)
+	span.SetAttributes(tracing.Int("store.count", len(kvps)))
+	return kvps, err
+}
+
+func (t *traced) Delete(ctx context.Context, key string) error {
+	ctx, span := t.start(ctx, "Delete", key)
+	defer span.End()
+
+	err := t.store.Delete(ctx, key)
+	span.RecordError(err)
+	return err
+}
+
+// Snapshot - implements Snapshotter when the wrapped store does
+func (t *traced) Snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	s, ok := t.store.(Snapshotter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return s.Snapshot(ctx, w)
+}
+
+// Restore - implements Snapshotter when the wrapped store does
+func (t *traced) Restore(ctx context.Context, r io.Reader, validate func(*KVPair) error) error {
+	s, ok := t.store.(Snapshotter)
+	if !ok {
+		return ErrNotSupported
+	}
+	return s.Restore(ctx, r, validate)
+}
+
+// Size - implements Sizer, -1 when the wrapped store doesn't
+func (t *traced) Size() (int64, error) {
+	s, ok := t.store.(Sizer)
+	if !ok {
+		return -1, nil
+	}
+	return s.Size()
+}
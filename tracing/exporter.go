@@ -0,0 +1,289 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exporters
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// DefaultOTLPEndpoint - default OTLP/HTTP collector address
+const DefaultOTLPEndpoint = "http://localhost:4318"
+
+// ErrUnknownExporter - exporter name isn't supported
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// NewExporter - creates exporter by name, stdout or otlp. Endpoint, service
+// name and version are only used by the OTLP exporter.
+func NewExporter(name, endpoint, service, version string, w io.Writer) (Exporter, error) {
+	switch strings.ToLower(name) {
+	case ExporterStdout:
+		return NewStdoutExporter(w), nil
+	case ExporterOTLP:
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		return NewOTLPExporter(endpoint, service, version, nil), nil
+	}
+	return nil, fmt.Errorf("%w: %q, expected %s or %s", ErrUnknownExporter, name, ExporterStdout, ExporterOTLP)
+}
+
+// StdoutExporter - writes spans as JSON lines, e.g. for local testing
+type StdoutExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewStdoutExporter - create new exporter writing to w
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+	return &StdoutExporter{w: w}
+}
+
+type stdoutSpan struct {
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	TraceID    string                 `json:"traceID"`
+	SpanID     string                 `json:"spanID"`
+	ParentID   string                 `json:"parentID,omitempty"`
+	Start      time.Time              `json:"start"`
+	DurationMS float64                `json:"durationMs"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// Export - implements Exporter
+func (e *StdoutExporter) Export(ctx context.Context, spans []*SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		out := stdoutSpan{
+			Name:       s.Name,
+			Kind:       s.Kind.String(),
+			TraceID:    s.Context.TraceID.String(),
+			SpanID:     s.Context.SpanID.String(),
+			Start:      s.Start,
+			DurationMS: float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+			Error:      s.Error,
+		}
+		if s.Parent != (SpanID{}) {
+			out.ParentID = s.Parent.String()
+		}
+		if len(s.Attributes) > 0 {
+			out.Attributes = make(map[string]interface{}, len(s.Attributes))
+			for _, a := range s.Attributes {
+				out.Attributes[a.Key] = a.Value
+			}
+		}
+		if err := enc.Encode(&out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLPExporter - sends spans to an OpenTelemetry collector with the
+// OTLP/HTTP JSON encoding
+type OTLPExporter struct {
+	url        string
+	headers    map[string]string
+	client     *http.Client
+	resource   []Attribute
+	retryDelay time.Duration
+}
+
+// otlpMaxAttempts - exports failing with a transient error are retried,
+// spans are dropped once all attempts failed
+const otlpMaxAttempts = 3
+
+// NewOTLPExporter - create new exporter sending spans of the service to
+// the collector at endpoint, e.g. http://localhost:4318. Version is left
+// out of the resource when empty.
+func NewOTLPExporter(endpoint, service, version string, headers map[string]string) *OTLPExporter {
+	resource := []Attribute{String("service.name", service)}
+	if version != "" {
+		resource = append(resource, String("service.version", version))
+	}
+	return &OTLPExporter{
+		url:        strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:    headers,
+		resource:   resource,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		retryDelay: time.Second,
+	}
+}
+
+// OTLP JSON encoding, see opentelemetry-proto's trace.proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// OTLP status codes
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case bool:
+			v.BoolValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		out = append(out, otlpAttribute{Key: a.Key, Value: v})
+	}
+	return out
+}
+
+// Export - implements Exporter
+func (e *OTLPExporter) Export(ctx context.Context, spans []*SpanData) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/storageos/discovery"}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	body, err := json.Marshal(&otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	delay := e.retryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := e.send(ctx, body)
+		if err == nil || !retry || attempt == otlpMaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// send - posts the encoded spans, retry tells whether the collector may
+// accept them later
+func (e *OTLPExporter) send(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	// transient failures according to the OTLP/HTTP specification
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return true, fmt.Errorf("collector responded with status code %d", resp.StatusCode)
+	}
+	return false, fmt.Errorf("collector responded with status code %d", resp.StatusCode)
+}
@@ -0,0 +1,45 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport - starts a client span for every request and propagates it
+// with the traceparent header. Requests whose context carries a span
+// continue its trace.
+type Transport struct {
+	Tracer *Tracer
+	// Base defaults to http.DefaultTransport
+	Base http.RoundTripper
+}
+
+// RoundTrip - implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := t.Tracer.StartRoot(req.Context(), "HTTP "+req.Method, SpanKindClient, SpanContext{},
+		String("http.method", req.Method),
+		String("http.url", req.URL.String()),
+	)
+	defer span.End()
+
+	// round trippers must not modify the caller's request
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.RecordError(fmt.Errorf("status code %d", resp.StatusCode))
+	}
+	return resp, nil
+}
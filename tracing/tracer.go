@@ -0,0 +1,264 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default batching of exported spans
+const (
+	DefaultBatchSize     = 256
+	DefaultFlushInterval = 5 * time.Second
+	defaultQueueSize     = 4096
+)
+
+// samplers, named like the OTEL_TRACES_SAMPLER values of the OpenTelemetry
+// SDKs. Traces continued from a remote parent always follow its decision.
+const (
+	SamplerAlwaysOn  = "parentbased_always_on"
+	SamplerAlwaysOff = "parentbased_always_off"
+	SamplerRatio     = "parentbased_traceidratio"
+)
+
+// ErrUnknownSampler - sampler name isn't supported
+var ErrUnknownSampler = errors.New("unknown trace sampler")
+
+// ParseSampler - ratio of new traces sampled by the named sampler, arg is
+// the ratio of the traceidratio sampler and defaults to 1
+func ParseSampler(name, arg string) (float64, error) {
+	switch strings.ToLower(name) {
+	case "", SamplerAlwaysOn:
+		return 1, nil
+	case SamplerAlwaysOff:
+		return 0, nil
+	case SamplerRatio:
+		if arg == "" {
+			return 1, nil
+		}
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return 0, fmt.Errorf("invalid sampler ratio %q, expected a number between 0 and 1", arg)
+		}
+		return ratio, nil
+	}
+	return 0, fmt.Errorf("%w: %q, expected %s, %s or %s", ErrUnknownSampler, name, SamplerAlwaysOn, SamplerAlwaysOff, SamplerRatio)
+}
+
+// Exporter - sends finished spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []*SpanData) error
+}
+
+// Tracer - starts root and remote child spans and exports finished spans
+// in batches from a background goroutine
+type Tracer struct {
+	exporter Exporter
+
+	batchSize     int
+	flushInterval time.Duration
+	sampleRatio   float64
+
+	queue    chan *SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Option is used to pass optional arguments to
+// the Tracer constructor
+type Option interface {
+	Configure(*Tracer) error
+}
+
+// OptionFn is a type of Option that is represented
+// by a single function that gets called for Configure()
+type OptionFn func(*Tracer) error
+
+// Configure - configures specific variable
+func (o OptionFn) Configure(t *Tracer) error {
+	return o(t)
+}
+
+// WithBatchSize - number of spans exported at once
+func WithBatchSize(n int) Option {
+	return OptionFn(func(t *Tracer) error {
+		t.batchSize = n
+		return nil
+	})
+}
+
+// WithFlushInterval - how often spans are exported when batches don't fill
+// up
+func WithFlushInterval(d time.Duration) Option {
+	return OptionFn(func(t *Tracer) error {
+		t.flushInterval = d
+		return nil
+	})
+}
+
+// WithSampleRatio - fraction of new traces that are sampled, traces
+// continued from a parent follow the parent's decision
+func WithSampleRatio(ratio float64) Option {
+	return OptionFn(func(t *Tracer) error {
+		t.sampleRatio = ratio
+		return nil
+	})
+}
+
+// New - create new tracer exporting spans with the exporter
+func New(exporter Exporter, options ...Option) *Tracer {
+	t := &Tracer{
+		exporter:      exporter,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		sampleRatio:   1,
+		queue:         make(chan *SpanData, defaultQueueSize),
+		flush:         make(chan chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range options {
+		opt.Configure(t)
+	}
+
+	go t.run()
+	return t
+}
+
+// StartRoot - starts span of an incoming or outgoing request. The span
+// continues the span carried by ctx or the remote parent, a new trace is
+// started when there is neither.
+func (t *Tracer) StartRoot(ctx context.Context, name string, kind SpanKind, remote SpanContext, attrs ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := remote
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		parent = sc
+	}
+	return t.start(ctx, name, kind, parent, attrs)
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind SpanKind, parent SpanContext, attrs []Attribute) (context.Context, *Span) {
+	s := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			Start:      time.Now(),
+			Attributes: attrs,
+		},
+	}
+
+	if parent.IsValid() {
+		s.data.Context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+		s.data.Parent = parent.SpanID
+	} else {
+		traceID := newTraceID()
+		s.data.Context = SpanContext{TraceID: traceID, SpanID: newSpanID(), Sampled: t.sample(traceID)}
+	}
+
+	return ContextWithSpan(ctx, s), s
+}
+
+// sample - decides on new traces by their ID like the OpenTelemetry
+// traceidratio sampler, so the decision is the same wherever it's made
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.sampleRatio >= 1:
+		return true
+	case t.sampleRatio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:])>>1 < uint64(t.sampleRatio*(1<<63))
+}
+
+// export - queues the span, spans are dropped rather than blocking requests
+// when the exporter can't keep up
+func (t *Tracer) export(data *SpanData) {
+	select {
+	case <-t.done:
+	case t.queue <- data:
+	default:
+		slog.Warn("dropping span, export queue is full", "span", data.Name)
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(t.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*SpanData, 0, t.batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(context.Background(), batch); err != nil {
+			slog.Error("failed to export spans", "count", len(batch), "err", err)
+		}
+		batch = make([]*SpanData, 0, t.batchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= t.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-t.flush:
+			t.drain(&batch)
+			send()
+			close(flushed)
+		case <-t.done:
+			t.drain(&batch)
+			send()
+			return
+		}
+	}
+}
+
+func (t *Tracer) drain(batch *[]*SpanData) {
+	for {
+		select {
+		case data := <-t.queue:
+			*batch = append(*batch, data)
+		default:
+			return
+		}
+	}
+}
+
+// Flush - exports queued spans, nil tracers do nothing
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+		<-flushed
+	case <-t.done:
+	}
+}
+
+// Stop - exports queued spans and stops the tracer, spans ending later are
+// discarded
+func (t *Tracer) Stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		t.Flush()
+		close(t.done)
+	})
+}
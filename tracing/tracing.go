@@ -0,0 +1,259 @@
+// Package tracing records spans of requests as they pass through the
+// server, cluster manager and store, and propagates them between services
+// with the W3C Trace Context traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderTraceparent - W3C Trace Context header
+const HeaderTraceparent = "traceparent"
+
+// ErrInvalidTraceparent - traceparent header can't be parsed
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID - identifies a trace
+type TraceID [16]byte
+
+// String - lowercase hex encoding
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID - identifies a span within a trace
+type SpanID [8]byte
+
+// String - lowercase hex encoding
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext - identity of a span propagated to child spans and other
+// services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid - whether trace and span IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent - traceparent header value of the span context
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent - parses traceparent header value, versions other than
+// 00 are accepted as long as they start with the version 00 fields
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, value)
+	}
+	if _, err := hex.Decode(make([]byte, 1), []byte(parts[0])); err != nil {
+		return sc, fmt.Errorf("%w: version %q", ErrInvalidTraceparent, parts[0])
+	}
+	if len(parts[1]) != 32 || strings.ToLower(parts[1]) != parts[1] {
+		return sc, fmt.Errorf("%w: trace ID %q", ErrInvalidTraceparent, parts[1])
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("%w: trace ID %q", ErrInvalidTraceparent, parts[1])
+	}
+	if len(parts[2]) != 16 || strings.ToLower(parts[2]) != parts[2] {
+		return sc, fmt.Errorf("%w: parent ID %q", ErrInvalidTraceparent, parts[2])
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("%w: parent ID %q", ErrInvalidTraceparent, parts[2])
+	}
+	flags := make([]byte, 1)
+	if len(parts[3]) != 2 {
+		return sc, fmt.Errorf("%w: flags %q", ErrInvalidTraceparent, parts[3])
+	}
+	if _, err := hex.Decode(flags, []byte(parts[3])); err != nil {
+		return sc, fmt.Errorf("%w: flags %q", ErrInvalidTraceparent, parts[3])
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: all zero ID in %q", ErrInvalidTraceparent, value)
+	}
+	return sc, nil
+}
+
+// SpanKind - role of the span in the trace
+type SpanKind int
+
+// span kinds, values match OTLP
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute - key value pair describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String - string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int - integer attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Bool - boolean attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// SpanData - finished span handed to exporters
+type SpanData struct {
+	Name       string
+	Kind       SpanKind
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error is the status message of failed spans
+	Error string
+}
+
+// Span - operation being traced. Methods of a nil span do nothing, so
+// callers don't have to check whether tracing is enabled.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext - identity of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttributes - adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attrs...)
+}
+
+// RecordError - marks the span as failed, nil errors are ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End - finishes the span, sampled spans are exported. Only the first call
+// has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.Context.Sampled {
+		s.tracer.export(&data)
+	}
+}
+
+type spanKey struct{}
+
+// ContextWithSpan - returns context carrying the span
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext - span carried by the context, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start - starts a child of the span carried by ctx, nothing is traced
+// when ctx carries no span. Packages below the server use it so they don't
+// need a tracer of their own.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.start(ctx, name, SpanKindInternal, parent.data.Context, attrs)
+}
+
+// Inject - sets the traceparent header of the span carried by ctx
+func Inject(ctx context.Context, header http.Header) {
+	if sc := SpanFromContext(ctx).SpanContext(); sc.IsValid() {
+		header.Set(HeaderTraceparent, sc.Traceparent())
+	}
+}
+
+// Extract - remote parent from the traceparent header, invalid headers
+// start a new trace
+func Extract(header http.Header) (SpanContext, bool) {
+	value := header.Get(HeaderTraceparent)
+	if value == "" {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
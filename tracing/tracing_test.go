@@ -0,0 +1,245 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recorder - exporter keeping spans in memory
+type recorder struct {
+	spans []*SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, spans []*SpanData) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	testcases := []struct {
+		value   string
+		sampled bool
+		valid   bool
+	}{
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true, valid: true},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", sampled: false, valid: true},
+		// future versions may append fields
+		{value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", sampled: true, valid: true},
+		{value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{value: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7"},
+		{value: "garbage"},
+	}
+
+	for _, tc := range testcases {
+		sc, err := ParseTraceparent(tc.value)
+		if !tc.valid {
+			if !errors.Is(err, ErrInvalidTraceparent) {
+				t.Errorf("%s: expected ErrInvalidTraceparent, got %v", tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.value, err)
+			continue
+		}
+		if sc.Sampled != tc.sampled {
+			t.Errorf("%s: expected sampled %t", tc.value, tc.sampled)
+		}
+		if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+			t.Errorf("%s: unexpected span context %s", tc.value, sc.Traceparent())
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	if _, span := Start(context.Background(), "untraced"); span != nil {
+		t.Errorf("expected no span without a parent")
+	}
+
+	exporter := &recorder{}
+	tracer := New(exporter)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.StartRoot(context.Background(), "root", SpanKindServer, remote)
+	_, child := Start(ctx, "child", String("key", "value"))
+	child.RecordError(errors.New("failed"))
+	child.End()
+	root.End()
+	root.End()
+	tracer.Stop()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(exporter.spans))
+	}
+	c, r := exporter.spans[0], exporter.spans[1]
+	if r.Context.TraceID != remote.TraceID || r.Parent != remote.SpanID {
+		t.Errorf("expected root span to continue the remote trace")
+	}
+	if c.Context.TraceID != remote.TraceID || c.Parent != r.Context.SpanID {
+		t.Errorf("expected child span to be a child of the root span")
+	}
+	if c.Error != "failed" || len(c.Attributes) != 1 || c.Kind != SpanKindInternal {
+		t.Errorf("unexpected child span: %+v", c)
+	}
+}
+
+func TestUnsampled(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.StartRoot(context.Background(), "root", SpanKindServer, remote)
+	span.End()
+	tracer.Stop()
+
+	if len(exporter.spans) != 0 {
+		t.Errorf("expected spans of unsampled traces to be dropped, got %d", len(exporter.spans))
+	}
+}
+
+func TestSampleRatio(t *testing.T) {
+	for _, tc := range []struct {
+		sampler, arg string
+		ratio        float64
+		valid        bool
+	}{
+		{"", "", 1, true},
+		{SamplerAlwaysOff, "", 0, true},
+		{SamplerRatio, "0.25", 0.25, true},
+		{SamplerRatio, "2", 0, false},
+		{"always_on", "", 0, false},
+	} {
+		ratio, err := ParseSampler(tc.sampler, tc.arg)
+		if (err == nil) != tc.valid || ratio != tc.ratio {
+			t.Errorf("%s %q: unexpected ratio %v: %v", tc.sampler, tc.arg, ratio, err)
+		}
+	}
+
+	exporter := &recorder{}
+	tracer := New(exporter, WithSampleRatio(0.25))
+
+	sampled := 0
+	for i := 0; i < 1000; i++ {
+		_, span := tracer.StartRoot(context.Background(), "root", SpanKindServer, SpanContext{})
+		if span.SpanContext().Sampled {
+			sampled++
+		}
+		span.End()
+	}
+
+	// continued traces follow the parent's decision
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	unsampled := New(&recorder{}, WithSampleRatio(0))
+	_, span := unsampled.StartRoot(context.Background(), "root", SpanKindServer, remote)
+	if !span.SpanContext().Sampled {
+		t.Errorf("expected sampled parent to be followed")
+	}
+	unsampled.Stop()
+	tracer.Stop()
+
+	if sampled < 150 || sampled > 350 || len(exporter.spans) != sampled {
+		t.Errorf("expected about a quarter of 1000 traces to be sampled and exported, got %d, %d exported", sampled, len(exporter.spans))
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(HeaderTraceparent)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	exporter := &recorder{}
+	tracer := New(exporter)
+
+	client := &http.Client{Transport: &Transport{Tracer: tracer}}
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tracer.Stop()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("expected client span, got %d spans", len(exporter.spans))
+	}
+	span := exporter.spans[0]
+	if received != span.Context.Traceparent() {
+		t.Errorf("expected traceparent %s, server received %q", span.Context.Traceparent(), received)
+	}
+	if span.Kind != SpanKindClient || span.Error == "" {
+		t.Errorf("expected failed client span: %+v", span)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var req otlpRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %s", err)
+		}
+	}))
+	defer ts.Close()
+
+	tracer := New(NewOTLPExporter(ts.URL, "discovery-test", "1.2.3", nil))
+	_, span := tracer.StartRoot(context.Background(), "root", SpanKindServer, SpanContext{}, Int("http.status_code", 500))
+	span.RecordError(errors.New("failed"))
+	span.End()
+	tracer.Stop()
+
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	resource := req.ResourceSpans[0].Resource.Attributes
+	if service := resource[0].Value.StringValue; service == nil || *service != "discovery-test" {
+		t.Errorf("expected service name resource attribute")
+	}
+	if len(resource) != 2 || resource[1].Key != "service.version" || *resource[1].Value.StringValue != "1.2.3" {
+		t.Errorf("expected service version resource attribute, got: %+v", resource)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if s.TraceID != span.SpanContext().TraceID.String() || s.Kind != int(SpanKindServer) || s.Status.Code != otlpStatusError {
+		t.Errorf("unexpected span: %+v", s)
+	}
+	if v := s.Attributes[0].Value.IntValue; v == nil || *v != "500" {
+		t.Errorf("expected integer attribute to be encoded as string")
+	}
+}
+
+func TestOTLPExporterRetry(t *testing.T) {
+	codes := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest, http.StatusBadRequest}
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(codes[requests])
+		requests++
+	}))
+	defer ts.Close()
+
+	exporter := NewOTLPExporter(ts.URL, "discovery-test", "", nil)
+	exporter.retryDelay = time.Millisecond
+	spans := []*SpanData{{Name: "span"}}
+
+	if err := exporter.Export(context.Background(), spans); err != nil || requests != 2 {
+		t.Errorf("expected export to succeed on the second attempt, got %d requests: %v", requests, err)
+	}
+	if err := exporter.Export(context.Background(), spans); err == nil || requests != 3 {
+		t.Errorf("expected rejected export not to be retried, got %d requests: %v", requests, err)
+	}
+}
@@ -13,6 +13,8 @@ type ClusterCreateOps struct {
 	TTL  int64
 	Name string
 	Size int
+	// node slots only matching nodes can take
+	Reservations []Reservation
 }
 
 type Cluster struct {
@@ -32,17 +34,219 @@ type Cluster struct {
 
 	CreatedAt time.Time `json:"createdAt,omitempty"`
 	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+
+	// record version, incremented on every write
+	Version uint64 `json:"version,omitempty"`
+
+	// set when cluster was deleted, deleted clusters can be restored until
+	// the delete grace period passes
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// bootstrap phase, maintained by the cluster manager
+	Phase ClusterPhase `json:"phase,omitempty"`
+	// set once the first Size nodes registered
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	// IDs of the first Size nodes, in registration order
+	FoundingMembers []string `json:"foundingMembers,omitempty"`
+	// set for clusters created with a TTL
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// node initializing the cluster, designated once it completes
+	Leader *Leader `json:"leader,omitempty"`
+
+	// reserved node slots, the remaining slots up to Size are taken by
+	// nodes in registration order
+	Reservations []Reservation `json:"reservations,omitempty"`
 }
 
-type Node struct {
-	ID               string `json:"id,omitempty"` // node/controller UUID
+// Reservation - node slot reserved for a node with the given name and/or
+// address, or for the node presenting the slot's one-time token
+type Reservation struct {
 	Name             string `json:"name,omitempty"`
 	AdvertiseAddress string `json:"advertiseAddress,omitempty"`
+	// requests a token for the slot when the cluster is created
+	RequireToken bool `json:"requireToken,omitempty"`
+	// token the node registers with, only returned when the cluster is
+	// created
+	Token string `json:"token,omitempty"`
+	// hash of the token
+	TokenHash string `json:"tokenHash,omitempty"`
+
+	// node holding the slot, the slot is released when it deregisters
+	NodeID string `json:"nodeID,omitempty"`
+}
+
+// Leader - bootstrap leader, only the node holding the current epoch may
+// initialize the cluster
+type Leader struct {
+	NodeID string `json:"nodeID"`
+	// fencing epoch, incremented on every handover
+	Epoch     uint64    `json:"epoch"`
+	ElectedAt time.Time `json:"electedAt"`
+	// set once the leader confirmed initialization
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+// LeaderRequest - confirms or hands over bootstrap leadership
+type LeaderRequest struct {
+	NodeID string `json:"nodeID"`
+	Epoch  uint64 `json:"epoch"`
+}
+
+// ClusterUpdate - cluster fields that can be changed after creation, nil
+// fields are left unchanged
+type ClusterUpdate struct {
+	Name *string `json:"name,omitempty"`
+	Size *int    `json:"size,omitempty"`
+	// seconds from now until the cluster expires, 0 removes the expiry
+	TTL *int64 `json:"ttl,omitempty"`
+}
+
+// ClusterPhase - cluster bootstrap phase
+type ClusterPhase string
+
+// cluster phases
+const (
+	// ClusterPhaseForming - fewer than Size nodes registered
+	ClusterPhaseForming ClusterPhase = "Forming"
+	// ClusterPhaseComplete - founding members registered, waiting for all
+	// of them to report in
+	ClusterPhaseComplete ClusterPhase = "Complete"
+	// ClusterPhaseActive - all members are heartbeating
+	ClusterPhaseActive ClusterPhase = "Active"
+	// ClusterPhaseDegraded - members left or stopped heartbeating after the
+	// cluster completed
+	ClusterPhaseDegraded ClusterPhase = "Degraded"
+	// ClusterPhaseExpired - cluster TTL passed, no further changes are
+	// accepted
+	ClusterPhaseExpired ClusterPhase = "Expired"
+)
+
+type Node struct {
+	ID   string `json:"id,omitempty"` // node/controller UUID
+	Name string `json:"name,omitempty"`
+	// primary address, defaults to the first endpoint when not set
+	AdvertiseAddress string `json:"advertiseAddress,omitempty"`
+	// additional named addresses, e.g. separate data and management
+	// networks or IPv4 and IPv6 addresses of dual-stack nodes
+	Endpoints []Endpoint `json:"endpoints,omitempty"`
+
+	// free-form labels, e.g. rack=r1
+	Labels map[string]string `json:"labels,omitempty"`
+	// node role, one of the NodeRole constants
+	Role   string `json:"role,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+	Region string `json:"region,omitempty"`
+	// node software version
+	Version string `json:"version,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+	// last registration or heartbeat
+	LastSeen time.Time `json:"lastSeen,omitempty"`
+
+	// token of the reserved slot the node registers for, not stored
+	SlotToken string `json:"slotToken,omitempty"`
+}
+
+// Endpoint - named node address, e.g. {"name": "peer", "url": "http://10.0.0.1:2380"}
+type Endpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// NodeUpdate - node fields that can be updated after registration, nil
+// fields are left unchanged
+type NodeUpdate struct {
+	Name             *string `json:"name,omitempty"`
+	AdvertiseAddress *string `json:"advertiseAddress,omitempty"`
+
+	// replaces all endpoints when set, an empty list removes them
+	Endpoints *[]Endpoint `json:"endpoints,omitempty"`
+
+	// replaces all labels when set, an empty map removes them
+	Labels  *map[string]string `json:"labels,omitempty"`
+	Role    *string            `json:"role,omitempty"`
+	Zone    *string            `json:"zone,omitempty"`
+	Region  *string            `json:"region,omitempty"`
+	Version *string            `json:"version,omitempty"`
+}
+
+// NodeResult - outcome of registering one node of a batch
+type NodeResult struct {
+	// position of the node in the batch
+	Index int    `json:"index"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	// one of the NodeResult constants
+	Status string `json:"status"`
+	// why the node was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// node batch results
+const (
+	// NodeResultRegistered - node was added to the cluster
+	NodeResultRegistered = "registered"
+	// NodeResultExists - node was already registered, nothing changed
+	NodeResultExists = "exists"
+	// NodeResultRejected - node is invalid or clashes with a registered node
+	// or an earlier node of the batch
+	NodeResultRejected = "rejected"
+	// NodeResultAborted - node is fine but wasn't registered because
+	// another node of the batch was rejected
+	NodeResultAborted = "aborted"
+)
+
+// NodeBatchResult - reply to a batch registration, cluster is only set
+// when the batch was applied
+type NodeBatchResult struct {
+	Cluster *Cluster     `json:"cluster,omitempty"`
+	Results []NodeResult `json:"results"`
+}
 
+// node roles
+const (
+	NodeRoleController = "controller"
+	NodeRoleStorage    = "storage"
+	NodeRoleWitness    = "witness"
+)
+
+// Account - customer owning clusters
+type Account struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Quota     Quota     `json:"quota"`
 	CreatedAt time.Time `json:"createdAt,omitempty"`
 	UpdatedAt time.Time `json:"updatedAt,omitempty"`
 }
 
+// Quota - per-account limits, zero values are unlimited
+type Quota struct {
+	// number of clusters, deleted clusters don't count
+	MaxClusters int `json:"maxClusters,omitempty"`
+	MaxSize     int `json:"maxSize,omitempty"`
+	// seconds, clusters of accounts with a TTL limit always expire
+	MaxTTL int64 `json:"maxTTL,omitempty"`
+}
+
+// AccountUpdate - account fields that can be changed, nil fields are left
+// unchanged
+type AccountUpdate struct {
+	Name  *string `json:"name,omitempty"`
+	Quota *Quota  `json:"quota,omitempty"`
+}
+
+// APIKey - credential scoped to an account
+type APIKey struct {
+	ID        string `json:"id"`
+	AccountID string `json:"accountID"`
+	// Key is only returned when the key is created, e.g.
+	// "<id>.<secret>"
+	Key       string    `json:"key,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+}
+
 // VersionInfo describes the server's version and runtime info.
 type VersionInfo struct {
 	Name         string `json:"name"`
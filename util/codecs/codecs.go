@@ -3,6 +3,9 @@ package codecs
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 )
 
@@ -34,6 +37,17 @@ func DefaultSerializer() Serializer {
 	return &GobSerializer{}
 }
 
+// New - returns serializer for the given type, matching is case insensitive
+func New(serializerType string) (Serializer, error) {
+	switch strings.ToUpper(serializerType) {
+	case "", "GOB":
+		return &GobSerializer{}, nil
+	case "JSON":
+		return &JSONSerializer{}, nil
+	}
+	return nil, fmt.Errorf("unknown serializer type: %s", serializerType)
+}
+
 // GobSerializer - gob based serializer
 type GobSerializer struct{}
 
@@ -46,7 +60,10 @@ func (s *GobSerializer) Encode(source interface{}) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	// the buffer goes back to the pool, callers get their own copy
+	bts := make([]byte, buf.Len())
+	copy(bts, buf.Bytes())
+	return bts, nil
 }
 
 // Decode - decodes given bytes into target struct
@@ -60,3 +77,21 @@ func (s *GobSerializer) Decode(data []byte, target interface{}) error {
 func (s *GobSerializer) Type() string {
 	return "GOB"
 }
+
+// JSONSerializer - json based serializer
+type JSONSerializer struct{}
+
+// Encode - encodes source into bytes using JSON encoder
+func (s *JSONSerializer) Encode(source interface{}) ([]byte, error) {
+	return json.Marshal(source)
+}
+
+// Decode - decodes given bytes into target struct
+func (s *JSONSerializer) Decode(data []byte, target interface{}) error {
+	return json.Unmarshal(data, target)
+}
+
+// Type - serializer type
+func (s *JSONSerializer) Type() string {
+	return "JSON"
+}
@@ -0,0 +1,70 @@
+// Package logging configures structured, leveled logging and carries
+// request scoped loggers in contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// log formats
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+)
+
+// field names shared by all packages
+const (
+	FieldRequestID = "request_id"
+	FieldClusterID = "cluster_id"
+	FieldNodeID    = "node_id"
+	FieldTraceID   = "trace_id"
+	FieldError     = "err"
+)
+
+// New - creates logger writing to w, format is json (default) or logfmt and
+// level one of debug, info (default), warn or error. Matching is case
+// insensitive.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return nil, fmt.Errorf("unknown log level: %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatLogfmt, "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format: %s", format)
+}
+
+type contextKey struct{}
+
+// WithContext - returns context carrying the logger
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext - logger carried by the context, the default logger when
+// there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}